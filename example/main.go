@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"os"
 	"psycache"
 	"sync"
 	"time"
@@ -17,16 +19,17 @@ const (
 	TYPE_2Q   = "twoQ"
 )
 
-// 生成当前时间 + 20秒
-func initTime() int64 {
-	return time.Now().UnixNano()/1e6 + 20000
-}
+// 缓存从写入起存活20秒
+const defaultTTL = 20 * time.Second
+
+// logger 输出包括每个请求在内的所有日志 便于观察节点间的调用过程
+var logger = psycache.NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
 
 // startCacheServer 在一个具体节点开启一个缓存服务
 func startCacheServer(addr string, addrs []string, group *psycache.Group, wg *sync.WaitGroup) {
 	defer wg.Done()
 	// New一个服务实例
-	svr, err := psycache.NewServer(addr)
+	svr, err := psycache.NewServer(addr, psycache.WithServerLogger(logger))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -60,8 +63,8 @@ func main() {
 			if v, ok := mysql[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}), TYPE_LFU, initTime(), 2)
+			return nil, psycache.ErrNotFound
+		}), TYPE_LFU, defaultTTL, 2, psycache.WithGroupLogger(logger))
 
 	addrMap := map[int]string{
 		8001: "localhost:8001",
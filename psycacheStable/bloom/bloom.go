@@ -0,0 +1,99 @@
+package bloom
+
+// bloom 为psycache提供布隆过滤器 用于在访问数据源前判断key是否一定不存在
+// 过滤器只会误判存在(假阳性) 不会误判不存在 因此被拒绝的key一定不在构建时给出的key集合中
+// 采用Kirsch-Mitzenmacher双重哈希 由一次64位哈希派生出全部k个位置
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// DefaultFalsePositiveRate 未指定假阳性率时使用的值
+const DefaultFalsePositiveRate = 0.01
+
+// Filter 是并发安全的布隆过滤器
+type Filter struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64 // 位数
+	k    uint64 // 哈希函数个数
+	n    int64  // 已加入的key数
+}
+
+// New 创建能容纳n个key且假阳性率约为fpRate的过滤器
+// 加入的key超过n后假阳性率会逐渐升高 此时应按新的规模重建
+func New(n int, fpRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultFalsePositiveRate
+	}
+	// m = -n*ln(p)/(ln2)^2  k = m/n*ln2
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add 将key加入过滤器
+func (f *Filter) Add(key string) {
+	h1, h2 := hash(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := uint64(0); i < f.k; i++ {
+		pos := (h1 + i*h2) % f.m
+		f.bits[pos/64] |= 1 << (pos % 64)
+	}
+	f.n++
+}
+
+// MayContain 返回false时key一定没有被加入过 返回true时key可能被加入过
+func (f *Filter) MayContain(key string) bool {
+	h1, h2 := hash(key)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := uint64(0); i < f.k; i++ {
+		pos := (h1 + i*h2) % f.m
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len 返回加入过的key数 重复加入的key会被重复计数
+func (f *Filter) Len() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.n
+}
+
+// hash 由FNV-1a派生出两个哈希值 h2为奇数 保证k个位置各不相同地散布在位数组中
+func hash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := mix64(h.Sum64())
+	return sum, mix64(sum) | 1
+}
+
+// mix64 splitmix64的终结函数 将输入的每一位均匀扩散到输出
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
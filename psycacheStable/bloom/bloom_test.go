@@ -0,0 +1,52 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add("key-" + strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.MayContain("key-" + strconv.Itoa(i)) {
+			t.Fatalf("key-%d was added but rejected", i)
+		}
+	}
+	if f.Len() != 1000 {
+		t.Fatalf("Actual: %d\tExpect: 1000", f.Len())
+	}
+}
+
+func TestFilter_FalsePositiveRate(t *testing.T) {
+	for _, rate := range []float64{0.1, 0.01, 0.001} {
+		f := New(10000, rate)
+		for i := 0; i < 10000; i++ {
+			f.Add("key-" + strconv.Itoa(i))
+		}
+		var fp int
+		const probes = 100000
+		for i := 0; i < probes; i++ {
+			if f.MayContain("missing-" + strconv.Itoa(i)) {
+				fp++
+			}
+		}
+		// 允许实际假阳性率比配置值高一倍
+		if actual := float64(fp) / probes; actual > 2*rate {
+			t.Errorf("false positive rate %.4f exceeds %.4f", actual, 2*rate)
+		}
+	}
+}
+
+func BenchmarkFilter_MayContain(b *testing.B) {
+	f := New(100000, 0.01)
+	for i := 0; i < 100000; i++ {
+		f.Add("key-" + strconv.Itoa(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.MayContain("key-" + strconv.Itoa(i))
+	}
+}
@@ -0,0 +1,309 @@
+package arc
+
+// arc 实现自适应替换缓存(Adaptive Replacement Cache) 参考Megiddo与Modha的论文
+// 常驻条目分为T1(只被访问过一次)与T2(被访问过至少两次)两个LRU链表
+// 因容量不足被淘汰的条目只留下key 分别进入幽灵链表B1与B2
+// 写入的key命中B1说明T1过小 命中B2说明T2过小 据此调整T1的目标大小p
+// 这样缓存能在偏重近期访问与偏重访问频率的负载之间自动切换 无需人工调参
+// 原论文按条目数计算容量 这里各链表的大小都按字节计算 幽灵条目记录其被淘汰时的大小
+// 幽灵条目不保存value 因此额外占用的内存只有key本身
+
+import (
+	"container/list"
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"time"
+)
+
+// entry 定义双向链表节点所存储的对象 幽灵条目的value为nil
+type entry struct {
+	key            string
+	value          cache.Lengthable
+	expirationTime time.Time
+	size           int64    // key与value合计的大小(Byte)
+	seg            *segment // 所在的链表
+}
+
+// segment 是一段LRU链表 链头表示最近使用
+type segment struct {
+	list  *list.List
+	bytes int64 // 该链表中条目合计的大小(Byte)
+}
+
+func newSegment() *segment {
+	return &segment{list: list.New()}
+}
+
+// ARCCache 是ARC算法实现的缓存
+type ARCCache struct {
+	capacity int64 // Cache 最大容量(Byte)
+	p        int64 // T1的目标大小(Byte) 在0到capacity之间自适应调整
+
+	t1      *segment // 只被访问过一次的常驻条目
+	t2      *segment // 被访问过至少两次的常驻条目
+	b1      *segment // 从T1淘汰的幽灵条目
+	b2      *segment // 从T2淘汰的幽灵条目
+	hashmap map[string]*list.Element
+	ghosts  map[string]*list.Element
+
+	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
+}
+
+// New 创建指定最大容量的ARC缓存
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func New(maxBytes int64, callback cache.OnEliminated) *ARCCache {
+	return &ARCCache{
+		capacity: maxBytes,
+		t1:       newSegment(),
+		t2:       newSegment(),
+		b1:       newSegment(),
+		b2:       newSegment(),
+		hashmap:  make(map[string]*list.Element),
+		ghosts:   make(map[string]*list.Element),
+		callback: callback,
+	}
+}
+
+// Get 从缓存获取对应key的value 命中的条目移动到T2链头
+// ok 指明查询结果 false代表查无此key 命中幽灵条目同样视为未命中
+func (c *ARCCache) Get(key string) (value cache.Lengthable, ok bool) {
+	elem, ok := c.hashmap[key]
+	if !ok {
+		return nil, false
+	}
+	kv := elem.Value.(*entry)
+	if checkExpirationTime(kv.expirationTime) {
+		c.expireElement(elem)
+		return nil, false
+	}
+	c.touch(elem)
+	return kv.value, true
+}
+
+// Add 向缓存中添加指定key的value
+// 新的key进入T1 命中幽灵条目的key先调整p 再直接进入T2
+func (c *ARCCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
+	size := int64(len(key)) + int64(value.Len())
+	if c.capacity != 0 && size > c.capacity { //新加的这个比总容量都要大了
+		return
+	}
+	if elem, ok := c.hashmap[key]; ok {
+		// 更新缓存key值 视为一次访问
+		kv := elem.Value.(*entry)
+		kv.seg.bytes += size - kv.size
+		kv.size = size
+		kv.value = value
+		kv.expirationTime = expirationTime
+		c.touch(elem)
+		c.makeRoom(0, false)
+		c.trimGhosts()
+		return
+	}
+	kv := &entry{key: key, value: value, expirationTime: expirationTime, size: size}
+	if elem, ok := c.ghosts[key]; ok {
+		ghost := elem.Value.(*entry)
+		inB2 := ghost.seg == c.b2
+		c.adapt(ghost)
+		c.removeGhost(elem)
+		c.makeRoom(size, inB2)
+		c.push(kv, c.t2)
+		c.trimGhosts()
+		return
+	}
+	// T1与B1合计不超过capacity 超出时先丢弃B1中最旧的幽灵条目 B1为空时直接淘汰T1中最旧的条目
+	for c.capacity != 0 && c.t1.bytes+c.b1.bytes+size > c.capacity {
+		if back := c.b1.list.Back(); back != nil {
+			c.removeGhost(back)
+			continue
+		}
+		c.evictElement(c.t1.list.Back())
+	}
+	c.makeRoom(size, false)
+	c.push(kv, c.t1)
+	c.trimGhosts()
+}
+
+// Remove 从缓存中移除提供的键 该key的幽灵条目一并删除
+func (c *ARCCache) Remove(key string) (ok bool) {
+	if e, exist := c.ghosts[key]; exist {
+		c.removeGhost(e)
+	}
+	if e, exist := c.hashmap[key]; exist {
+		c.removeElement(e)
+		return exist
+	}
+	return false
+}
+
+// Contains 检查某个键是否在缓存中，但不更新缓存的状态
+func (c *ARCCache) Contains(key string) (ok bool) {
+	e, ok := c.hashmap[key]
+	if ok {
+		// 判断此值是否已经超时,如果超时则进行删除
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			return !ok
+		}
+	}
+	return ok
+}
+
+// Len 获取缓存的长度 不包括幽灵条目
+func (c *ARCCache) Len() int {
+	return len(c.hashmap)
+}
+
+// touch 被再次访问的条目移动到T2链头
+func (c *ARCCache) touch(e *list.Element) {
+	kv := e.Value.(*entry)
+	if kv.seg == c.t2 {
+		c.t2.list.MoveToFront(e)
+		return
+	}
+	kv.seg.list.Remove(e)
+	kv.seg.bytes -= kv.size
+	c.push(kv, c.t2)
+}
+
+// push 将常驻条目放入目标链表的链头
+func (c *ARCCache) push(kv *entry, to *segment) {
+	kv.seg = to
+	c.hashmap[kv.key] = to.list.PushFront(kv)
+	to.bytes += kv.size
+}
+
+// adapt 根据命中的幽灵条目调整p
+// 命中B1时增大p 命中B2时减小p 另一侧幽灵链表越大 调整的幅度越大
+func (c *ARCCache) adapt(ghost *entry) {
+	if ghost.seg == c.b1 {
+		ratio := int64(1)
+		if c.b2.bytes > c.b1.bytes {
+			ratio = c.b2.bytes / c.b1.bytes
+		}
+		c.p = min(c.capacity, c.p+ratio*ghost.size)
+		return
+	}
+	ratio := int64(1)
+	if c.b1.bytes > c.b2.bytes {
+		ratio = c.b1.bytes / c.b2.bytes
+	}
+	c.p = max(0, c.p-ratio*ghost.size)
+}
+
+// makeRoom 淘汰常驻条目 直到能再放入size大小的条目
+func (c *ARCCache) makeRoom(size int64, inB2 bool) {
+	if c.capacity == 0 {
+		return
+	}
+	for c.t1.bytes+c.t2.bytes+size > c.capacity {
+		c.replace(inB2)
+	}
+}
+
+// replace 淘汰一枚常驻条目并留下幽灵条目
+// T1超过目标大小p时淘汰T1中最旧的条目 否则淘汰T2中最旧的条目
+func (c *ARCCache) replace(inB2 bool) {
+	if back := c.t1.list.Back(); back != nil && (c.t1.bytes > c.p || (inB2 && c.t1.bytes == c.p) || c.t2.list.Len() == 0) {
+		c.demote(back, c.b1)
+		return
+	}
+	c.demote(c.t2.list.Back(), c.b2)
+}
+
+// demote 淘汰一枚常驻条目 只保留key放入幽灵链表的链头
+func (c *ARCCache) demote(e *list.Element, ghost *segment) {
+	kv := e.Value.(*entry)
+	c.evictElement(e)
+	kv.value = nil
+	kv.seg = ghost
+	c.ghosts[kv.key] = ghost.list.PushFront(kv)
+	ghost.bytes += kv.size
+}
+
+// trimGhosts 限制幽灵链表的大小
+// T1与B1合计不超过capacity 四个链表合计不超过两倍capacity
+func (c *ARCCache) trimGhosts() {
+	if c.capacity == 0 {
+		return
+	}
+	for c.b1.list.Len() > 0 && c.t1.bytes+c.b1.bytes > c.capacity {
+		c.removeGhost(c.b1.list.Back())
+	}
+	for c.t1.bytes+c.t2.bytes+c.b1.bytes+c.b2.bytes > 2*c.capacity {
+		back := c.b2.list.Back()
+		if back == nil {
+			back = c.b1.list.Back()
+		}
+		if back == nil {
+			return
+		}
+		c.removeGhost(back)
+	}
+}
+
+// removeGhost 删除一枚幽灵条目
+func (c *ARCCache) removeGhost(e *list.Element) {
+	kv := e.Value.(*entry)
+	kv.seg.list.Remove(e)
+	kv.seg.bytes -= kv.size
+	delete(c.ghosts, kv.key)
+}
+
+// removeElement 删除一枚指定的常驻元素
+func (c *ARCCache) removeElement(e *list.Element) {
+	kv := e.Value.(*entry)
+	kv.seg.list.Remove(e)
+	kv.seg.bytes -= kv.size
+	delete(c.hashmap, kv.key)
+	if c.callback != nil {
+		c.callback(kv.key, kv.value)
+	}
+}
+
+// evictElement 因容量不足淘汰一枚元素
+func (c *ARCCache) evictElement(e *list.Element) {
+	c.evictions++
+	c.removeElement(e)
+}
+
+// expireElement 删除一枚已过期的元素 过期的条目不留下幽灵条目
+func (c *ARCCache) expireElement(e *list.Element) {
+	c.expirations++
+	c.removeElement(e)
+}
+
+// Stats 返回缓存的淘汰次数与占用情况 不包括幽灵条目
+func (c *ARCCache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.t1.bytes + c.t2.bytes,
+		Entries:     int64(len(c.hashmap)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *ARCCache) SweepExpired(n int) (checked, expired int) {
+	for _, e := range c.hashmap {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package arc
+
+import (
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lfu"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lru"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// 生成当前时间 + 2秒
+func initTime() time.Time {
+	return time.Now().Add(2 * time.Second)
+}
+
+func TestGet(t *testing.T) {
+	c := New(int64(100), nil)
+	c.Add("key1", String("1234"), initTime())
+	if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+	c.Add("key3", String("1234"), time.Now().Add(-time.Second))
+	if _, ok := c.Get("key3"); ok {
+		t.Fatalf("key3 should be expired")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := cache.OnEliminated(func(key string, value cache.Lengthable) {
+		keys = append(keys, key)
+	})
+	c := New(int64(10), callback)
+	c.Add("key1", String("123456"), initTime())
+	c.Add("k2", String("k2"), initTime())
+	c.Add("k3", String("k3"), initTime())
+	c.Add("k4", String("k4"), initTime())
+
+	expect := []string{"key1", "k2"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	c := New(int64(100), nil)
+	c.Add("myKey", String("1234"), initTime())
+	c.Get("myKey")
+	if !c.Remove("myKey") || c.Contains("myKey") || c.Len() != 0 {
+		t.Fatal("Remove myKey failed")
+	}
+	if stats := c.Stats(); stats.Bytes != 0 || stats.Entries != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// 再次写入刚被淘汰的key会命中幽灵条目 B1命中增大p B2命中减小p
+func TestAdapt(t *testing.T) {
+	c := New(int64(20), nil)
+	c.Add("a", String("123456789"), time.Time{})
+	c.Add("b", String("123456789"), time.Time{})
+	c.Get("b")                                   // b进入T2
+	c.Add("c", String("123456789"), time.Time{}) // T1超过p a从T1淘汰进入B1
+	if c.Contains("a") || c.b1.list.Len() != 1 || c.p != 0 {
+		t.Fatalf("a should be a ghost in B1, p=%d", c.p)
+	}
+	c.Add("a", String("123456789"), time.Time{})
+	if !c.Contains("a") || c.hashmap["a"].Value.(*entry).seg != c.t2 || c.p != 10 {
+		t.Fatalf("a should be promoted to T2 and p should grow to 10, p=%d", c.p)
+	}
+
+	c.Get("c")                                   // c进入T2
+	c.Add("d", String("123456789"), time.Time{}) // T1为空 淘汰T2最旧的a进入B2
+	if e, ok := c.ghosts["a"]; !ok || e.Value.(*entry).seg != c.b2 {
+		t.Fatalf("a should be a ghost in B2")
+	}
+	c.Add("a", String("123456789"), time.Time{})
+	if !c.Contains("a") || c.p != 0 {
+		t.Fatalf("p should shrink to 0, p=%d", c.p)
+	}
+}
+
+// 随机读写后 常驻条目不超过capacity T1与B1合计不超过capacity 四个链表合计不超过两倍capacity
+func TestCapacity(t *testing.T) {
+	const capacity = 500
+	c := New(int64(capacity), nil)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := "k" + strconv.Itoa(r.Intn(200))
+		if _, ok := c.Get(key); !ok {
+			c.Add(key, String(make([]byte, r.Intn(30))), time.Time{})
+		}
+		resident := c.t1.bytes + c.t2.bytes
+		if resident > capacity || c.t1.bytes+c.b1.bytes > capacity || resident+c.b1.bytes+c.b2.bytes > 2*capacity {
+			t.Fatalf("T1=%d T2=%d B1=%d B2=%d exceed capacity %d", c.t1.bytes, c.t2.bytes, c.b1.bytes, c.b2.bytes, capacity)
+		}
+		if c.p < 0 || c.p > capacity {
+			t.Fatalf("p=%d out of range", c.p)
+		}
+		if stats := c.Stats(); stats.Bytes != resident || int(stats.Entries) != c.t1.list.Len()+c.t2.list.Len() {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+	}
+}
+
+// policy 是各缓存算法在回放trace时共用的接口
+type policy interface {
+	Get(key string) (cache.Lengthable, bool)
+	Add(key string, value cache.Lengthable, expirationTime time.Time)
+}
+
+// replay 回放trace 返回命中率
+func replay(c policy, trace []string) float64 {
+	hits := 0
+	for _, key := range trace {
+		if _, ok := c.Get(key); ok {
+			hits++
+			continue
+		}
+		c.Add(key, String("01234567"), time.Time{})
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+// phaseTrace 交替生成偏重频率与偏重近期访问的两种阶段
+// 频率阶段是稳定的Zipf热点中夹杂只出现一次的扫描 近期阶段的热点集合每隔一段时间整体更换
+func phaseTrace(seed int64, phases, n int) []string {
+	r := rand.New(rand.NewSource(seed))
+	zipf := rand.NewZipf(r, 1.1, 1, 100000)
+	trace := make([]string, 0, phases*n)
+	scan := 0
+	for phase := 0; phase < phases; phase++ {
+		for i := 0; i < n; i++ {
+			if phase%2 == 0 {
+				trace = append(trace, "z"+strconv.FormatUint(zipf.Uint64(), 10))
+				if i%4 == 3 {
+					trace = append(trace, "s"+strconv.Itoa(scan))
+					scan++
+				}
+				continue
+			}
+			window := i / (n / 10)
+			trace = append(trace, "p"+strconv.Itoa(phase)+"-"+strconv.Itoa(window)+"-"+strconv.Itoa(r.Intn(800)))
+		}
+	}
+	return trace
+}
+
+// TestHitRatio 在交替变化的负载下 arc的命中率应不低于lru与lfu
+func TestHitRatio(t *testing.T) {
+	const capacity = 1000 * (8 + 16) // 约1000个条目
+	trace := phaseTrace(1, 4, 25000)
+	ratios := map[string]float64{
+		"arc": replay(New(capacity, nil), trace),
+		"lru": replay(lru.New(capacity, nil), trace),
+		"lfu": replay(lfu.New(capacity, nil), trace),
+	}
+	t.Logf("%v", ratios)
+	for _, other := range []string{"lru", "lfu"} {
+		if ratios["arc"] < ratios[other] {
+			t.Errorf("arc hit ratio %.4f is lower than %s %.4f", ratios["arc"], other, ratios[other])
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package clock
+
+// clock 实现CLOCK缓存 是对LRU的近似
+// 所有条目排成一个环 读路径只把条目的引用位置为1 不移动任何节点 因此只需要读锁 多个读者可以并行
+// 淘汰时指针沿环转动 引用位为1的条目清零后跳过(获得第二次机会) 遇到引用位为0的条目将其淘汰
+// ClockCache 自身是并发安全的
+
+import (
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// entry 是环上的节点
+type entry struct {
+	key            string
+	value          cache.Lengthable
+	expirationTime time.Time
+	referenced     atomic.Bool // 引用位 读路径在读锁下设置
+	prev, next     *entry
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+// ClockCache 是CLOCK算法实现的缓存
+type ClockCache struct {
+	mu       sync.RWMutex
+	capacity int64 // Cache 最大容量(Byte)
+	nowcap   int64 // Cache 当前容量(Byte)
+	hashmap  map[string]*entry
+	hand     *entry // 下一个检查的条目 环为空时为nil
+
+	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
+}
+
+// New 创建指定最大容量的CLOCK缓存
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func New(maxBytes int64, callback cache.OnEliminated) *ClockCache {
+	return &ClockCache{
+		capacity: maxBytes,
+		hashmap:  make(map[string]*entry),
+		callback: callback,
+	}
+}
+
+// Get 从缓存获取对应key的value 命中时只设置引用位
+// ok 指明查询结果 false代表查无此key
+func (c *ClockCache) Get(key string) (value cache.Lengthable, ok bool) {
+	c.mu.RLock()
+	e, ok := c.hashmap[key]
+	if !ok {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	if checkExpirationTime(e.expirationTime) {
+		c.mu.RUnlock()
+		c.expire(key)
+		return nil, false
+	}
+	if !e.referenced.Load() { // 已置位时不再写入 避免多核争抢同一缓存行
+		e.referenced.Store(true)
+	}
+	value = e.value
+	c.mu.RUnlock()
+	return value, true
+}
+
+// Add 向缓存中添加指定key的value 新条目插入在指针之前 即指针最晚转到的位置
+func (c *ClockCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
+	kvSize := int64(len(key)) + int64(value.Len())
+	if c.capacity != 0 && kvSize > c.capacity { //新加的这个比总容量都要大了
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.hashmap[key]; ok {
+		// 更新缓存key值
+		c.nowcap += kvSize - e.size()
+		e.value = value
+		e.expirationTime = expirationTime
+		e.referenced.Store(true)
+		c.makeRoom(0)
+		return
+	}
+	c.makeRoom(kvSize)
+	e := &entry{key: key, value: value, expirationTime: expirationTime}
+	c.link(e)
+	c.hashmap[key] = e
+	c.nowcap += kvSize
+}
+
+// Remove 从缓存中移除提供的键
+func (c *ClockCache) Remove(key string) (ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, exist := c.hashmap[key]; exist {
+		c.removeEntry(e)
+		return exist
+	}
+	return false
+}
+
+// Contains 检查某个键是否在缓存中，但不更新缓存的状态
+func (c *ClockCache) Contains(key string) (ok bool) {
+	c.mu.RLock()
+	e, ok := c.hashmap[key]
+	expired := ok && checkExpirationTime(e.expirationTime)
+	c.mu.RUnlock()
+	if expired {
+		// 判断此值是否已经超时,如果超时则进行删除
+		c.expire(key)
+		return false
+	}
+	return ok
+}
+
+// Len 获取缓存的长度
+func (c *ClockCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.hashmap)
+}
+
+// makeRoom 转动指针淘汰条目 直到能再放入size大小的条目
+func (c *ClockCache) makeRoom(size int64) {
+	for c.capacity != 0 && c.nowcap+size > c.capacity && c.hand != nil {
+		e := c.hand
+		if e.referenced.Load() {
+			e.referenced.Store(false)
+			c.hand = e.next
+			continue
+		}
+		c.evictions++
+		c.removeEntry(e)
+	}
+}
+
+// link 将条目插入环中指针之前
+func (c *ClockCache) link(e *entry) {
+	if c.hand == nil {
+		e.prev, e.next = e, e
+		c.hand = e
+		return
+	}
+	e.prev, e.next = c.hand.prev, c.hand
+	c.hand.prev.next = e
+	c.hand.prev = e
+}
+
+// removeEntry 删除一枚指定的条目 指针指向它时移到下一个条目
+func (c *ClockCache) removeEntry(e *entry) {
+	if e.next == e {
+		c.hand = nil
+	} else {
+		e.prev.next = e.next
+		e.next.prev = e.prev
+		if c.hand == e {
+			c.hand = e.next
+		}
+	}
+	e.prev, e.next = nil, nil
+	delete(c.hashmap, e.key)
+	c.nowcap -= e.size()
+	if c.callback != nil {
+		c.callback(e.key, e.value)
+	}
+}
+
+// expire 在写锁下重新检查并删除已过期的key 获取写锁期间key可能已被更新或删除
+func (c *ClockCache) expire(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.hashmap[key]; ok && checkExpirationTime(e.expirationTime) {
+		c.expirations++
+		c.removeEntry(e)
+	}
+}
+
+// Stats 返回缓存的淘汰次数与占用情况
+func (c *ClockCache) Stats() cache.Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.nowcap,
+		Entries:     int64(len(c.hashmap)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *ClockCache) SweepExpired(n int) (checked, expired int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.hashmap {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.expirationTime) {
+			c.expirations++
+			c.removeEntry(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
+		return true
+	}
+	return false
+}
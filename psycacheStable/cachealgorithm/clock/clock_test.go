@@ -0,0 +1,113 @@
+package clock
+
+import (
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// 生成当前时间 + 2秒
+func initTime() time.Time {
+	return time.Now().Add(2 * time.Second)
+}
+
+func TestGet(t *testing.T) {
+	c := New(int64(100), nil)
+	c.Add("key1", String("1234"), initTime())
+	if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+	c.Add("key3", String("1234"), time.Now().Add(-time.Second))
+	if _, ok := c.Get("key3"); ok || c.Stats().Expirations != 1 {
+		t.Fatalf("key3 should be expired")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := cache.OnEliminated(func(key string, value cache.Lengthable) {
+		keys = append(keys, key)
+	})
+	c := New(int64(10), callback)
+	c.Add("key1", String("123456"), initTime())
+	c.Add("k2", String("k2"), initTime())
+	c.Add("k3", String("k3"), initTime())
+	c.Add("k4", String("k4"), initTime())
+
+	expect := []string{"key1", "k2"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+}
+
+// 被访问过的条目获得第二次机会 淘汰下一个未被访问的条目
+func TestSecondChance(t *testing.T) {
+	c := New(int64(30), nil)
+	c.Add("a", String("123456789"), time.Time{})
+	c.Add("b", String("123456789"), time.Time{})
+	c.Add("c", String("123456789"), time.Time{})
+	c.Get("a")
+	c.Add("d", String("123456789"), time.Time{})
+	if !c.Contains("a") || c.Contains("b") || !c.Contains("c") || !c.Contains("d") {
+		t.Fatalf("b should be evicted instead of a")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	c := New(int64(100), nil)
+	c.Add("myKey", String("1234"), initTime())
+	c.Add("other", String("1234"), initTime())
+	if !c.Remove("myKey") || c.Contains("myKey") || c.Len() != 1 {
+		t.Fatal("Remove myKey failed")
+	}
+	if !c.Remove("other") || c.hand != nil {
+		t.Fatal("Remove other failed")
+	}
+	if stats := c.Stats(); stats.Bytes != 0 || stats.Entries != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// 并发读写后占用的容量仍不超过上限 配合-race检查数据竞争
+func TestConcurrentAccess(t *testing.T) {
+	const capacity = 1000
+	c := New(int64(capacity), nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				key := "k" + strconv.Itoa(r.Intn(200))
+				switch r.Intn(10) {
+				case 0:
+					c.Remove(key)
+				case 1:
+					c.Add(key, String("0123456789"), time.Now().Add(time.Duration(r.Intn(3)-1)*time.Millisecond))
+				default:
+					if _, ok := c.Get(key); !ok {
+						c.Add(key, String("0123456789"), time.Time{})
+					}
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	if stats := c.Stats(); stats.Bytes > capacity || int(stats.Entries) != c.Len() {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,344 @@
+package clockpro
+
+// clockpro 实现CLOCK-Pro缓存 参考Jiang、Chen与Zhang的论文
+// 常驻条目分为热条目与冷条目 冷条目被淘汰后只留下key成为测试条目 所有条目排在同一个环上
+// handCold淘汰冷条目: 被访问过的冷条目升级为热条目 未被访问过的淘汰为测试条目
+// handHot把热条目的引用位清零 再次转到时仍未被访问则降级为冷条目
+// handTest清理测试条目 测试条目在被清理前再次写入说明冷条目的份额过小 于是增大冷条目的目标大小 被清理时则减小
+// 与CLOCK相同 读路径只设置引用位 ClockProCache 自身是并发安全的
+// 原论文按页数计算容量 这里热、冷条目的大小按字节计算 测试条目不保存value 其数量不超过常驻条目数
+
+import (
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const minColdPercent = 1 // 冷条目的目标大小至少占总容量的百分比
+
+// status 是条目的类型
+type status uint8
+
+const (
+	hot  status = iota // 常驻的热条目
+	cold               // 常驻的冷条目
+	test               // 已被淘汰的冷条目 只保留key
+)
+
+// entry 是环上的节点 测试条目的value为nil
+type entry struct {
+	key            string
+	value          cache.Lengthable
+	expirationTime time.Time
+	size           int64 // key与value合计的大小(Byte)
+	status         status
+	referenced     atomic.Bool // 引用位 读路径在读锁下设置
+	prev, next     *entry
+}
+
+// ClockProCache 是CLOCK-Pro算法实现的缓存
+type ClockProCache struct {
+	mu         sync.RWMutex
+	capacity   int64 // Cache 最大容量(Byte)
+	coldTarget int64 // 冷条目的目标大小(Byte) 在总容量的minColdPercent%与capacity之间自适应调整
+	hotBytes   int64 // 热条目占用的内存大小(Byte)
+	coldBytes  int64 // 冷条目占用的内存大小(Byte)
+	tests      int   // 测试条目数
+	hashmap    map[string]*entry
+
+	handHot, handCold, handTest *entry // 环为空时均为nil
+
+	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
+}
+
+// New 创建指定最大容量的CLOCK-Pro缓存
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func New(maxBytes int64, callback cache.OnEliminated) *ClockProCache {
+	return &ClockProCache{
+		capacity:   maxBytes,
+		coldTarget: maxBytes / 2,
+		hashmap:    make(map[string]*entry),
+		callback:   callback,
+	}
+}
+
+// Get 从缓存获取对应key的value 命中时只设置引用位
+// ok 指明查询结果 false代表查无此key 测试条目同样视为未命中
+func (c *ClockProCache) Get(key string) (value cache.Lengthable, ok bool) {
+	c.mu.RLock()
+	e, ok := c.hashmap[key]
+	if !ok || e.status == test {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	if checkExpirationTime(e.expirationTime) {
+		c.mu.RUnlock()
+		c.expire(key)
+		return nil, false
+	}
+	if !e.referenced.Load() { // 已置位时不再写入 避免多核争抢同一缓存行
+		e.referenced.Store(true)
+	}
+	value = e.value
+	c.mu.RUnlock()
+	return value, true
+}
+
+// Add 向缓存中添加指定key的value
+// 新的key成为冷条目 测试条目被再次写入时直接成为热条目
+func (c *ClockProCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
+	kvSize := int64(len(key)) + int64(value.Len())
+	if c.capacity != 0 && kvSize > c.capacity { //新加的这个比总容量都要大了
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.hashmap[key]
+	if ok && e.status != test {
+		// 更新缓存key值
+		c.account(e, -1)
+		e.size = kvSize
+		c.account(e, 1)
+		e.value = value
+		e.expirationTime = expirationTime
+		e.referenced.Store(true)
+		c.makeRoom(0)
+		return
+	}
+	status := cold
+	if ok {
+		// 测试条目被再次写入 冷条目的份额过小
+		c.coldTarget = min(c.capacity, c.coldTarget+kvSize)
+		c.removeEntry(e)
+		status = hot
+	}
+	c.makeRoom(kvSize)
+	e = &entry{key: key, value: value, expirationTime: expirationTime, size: kvSize, status: status}
+	c.link(e)
+	c.hashmap[key] = e
+	c.account(e, 1)
+	c.balanceHot()
+}
+
+// Remove 从缓存中移除提供的键 该key的测试条目一并删除
+func (c *ClockProCache) Remove(key string) (ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, exist := c.hashmap[key]; exist {
+		c.removeEntry(e)
+		return e.status != test
+	}
+	return false
+}
+
+// Contains 检查某个键是否在缓存中，但不更新缓存的状态
+func (c *ClockProCache) Contains(key string) (ok bool) {
+	c.mu.RLock()
+	e, ok := c.hashmap[key]
+	ok = ok && e.status != test
+	expired := ok && checkExpirationTime(e.expirationTime)
+	c.mu.RUnlock()
+	if expired {
+		// 判断此值是否已经超时,如果超时则进行删除
+		c.expire(key)
+		return false
+	}
+	return ok
+}
+
+// Len 获取缓存的长度 不包括测试条目
+func (c *ClockProCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.residents()
+}
+
+// residents 返回常驻条目数
+func (c *ClockProCache) residents() int {
+	return len(c.hashmap) - c.tests
+}
+
+// account 将条目计入(n为1)或移出(n为-1)其类型的统计 常驻条目统计大小 测试条目统计个数
+func (c *ClockProCache) account(e *entry, n int64) {
+	switch e.status {
+	case hot:
+		c.hotBytes += n * e.size
+	case cold:
+		c.coldBytes += n * e.size
+	case test:
+		c.tests += int(n)
+	}
+}
+
+// makeRoom 淘汰冷条目 直到能再放入size大小的条目 没有冷条目时先降级热条目
+func (c *ClockProCache) makeRoom(size int64) {
+	if c.capacity == 0 {
+		return
+	}
+	for c.hotBytes+c.coldBytes+size > c.capacity {
+		if c.coldBytes == 0 {
+			c.runHandHot()
+			continue
+		}
+		c.runHandCold()
+	}
+}
+
+// balanceHot 热条目超过其份额时降级热条目
+func (c *ClockProCache) balanceHot() {
+	if c.capacity == 0 {
+		return
+	}
+	for c.hotBytes > c.capacity-c.coldTarget {
+		c.runHandHot()
+	}
+}
+
+// runHandCold 处理handCold指向的条目并前进一步
+func (c *ClockProCache) runHandCold() {
+	e := c.handCold
+	c.handCold = e.next
+	if e.status != cold {
+		return
+	}
+	if e.referenced.Load() {
+		// 冷条目在被淘汰前再次被访问 升级为热条目
+		e.referenced.Store(false)
+		c.account(e, -1)
+		e.status = hot
+		c.account(e, 1)
+		c.balanceHot()
+		return
+	}
+	c.evictions++
+	c.account(e, -1)
+	if c.callback != nil {
+		c.callback(e.key, e.value)
+	}
+	e.value = nil
+	e.status = test
+	c.account(e, 1)
+	for c.tests > 0 && c.tests > c.residents() {
+		c.runHandTest()
+	}
+}
+
+// runHandHot 处理handHot指向的条目并前进一步
+func (c *ClockProCache) runHandHot() {
+	e := c.handHot
+	c.handHot = e.next
+	if e.status != hot {
+		return
+	}
+	if e.referenced.Load() {
+		e.referenced.Store(false)
+		return
+	}
+	c.account(e, -1)
+	e.status = cold
+	c.account(e, 1)
+}
+
+// runHandTest 处理handTest指向的条目并前进一步 测试条目被清理时减小冷条目的份额
+func (c *ClockProCache) runHandTest() {
+	e := c.handTest
+	if e.status != test {
+		c.handTest = e.next
+		return
+	}
+	c.coldTarget = max(c.capacity*minColdPercent/100, c.coldTarget-e.size)
+	c.removeEntry(e) // handTest随之移到下一个条目
+}
+
+// link 将条目插入环中handHot之前 即各指针最晚转到的位置
+func (c *ClockProCache) link(e *entry) {
+	if c.handHot == nil {
+		e.prev, e.next = e, e
+		c.handHot, c.handCold, c.handTest = e, e, e
+		return
+	}
+	e.prev, e.next = c.handHot.prev, c.handHot
+	c.handHot.prev.next = e
+	c.handHot.prev = e
+}
+
+// removeEntry 从环中删除一枚条目 指向它的指针移到下一个条目 删除常驻条目时调用callback
+func (c *ClockProCache) removeEntry(e *entry) {
+	if e.next == e {
+		c.handHot, c.handCold, c.handTest = nil, nil, nil
+	} else {
+		e.prev.next = e.next
+		e.next.prev = e.prev
+		if c.handHot == e {
+			c.handHot = e.next
+		}
+		if c.handCold == e {
+			c.handCold = e.next
+		}
+		if c.handTest == e {
+			c.handTest = e.next
+		}
+	}
+	e.prev, e.next = nil, nil
+	delete(c.hashmap, e.key)
+	c.account(e, -1)
+	if e.status != test && c.callback != nil {
+		c.callback(e.key, e.value)
+	}
+}
+
+// expire 在写锁下重新检查并删除已过期的key 获取写锁期间key可能已被更新或删除 过期的条目不留下测试条目
+func (c *ClockProCache) expire(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.hashmap[key]; ok && e.status != test && checkExpirationTime(e.expirationTime) {
+		c.expirations++
+		c.removeEntry(e)
+	}
+}
+
+// Stats 返回缓存的淘汰次数与占用情况 不包括测试条目
+func (c *ClockProCache) Stats() cache.Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.hotBytes + c.coldBytes,
+		Entries:     int64(c.residents()),
+	}
+}
+
+// SweepExpired 随机检查至多n个常驻条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *ClockProCache) SweepExpired(n int) (checked, expired int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.hashmap {
+		if checked >= n {
+			break
+		}
+		if e.status == test {
+			continue
+		}
+		checked++
+		if checkExpirationTime(e.expirationTime) {
+			c.expirations++
+			c.removeEntry(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
+		return true
+	}
+	return false
+}
@@ -10,7 +10,7 @@ import (
 type entry struct {
 	key            string
 	value          cache.Lengthable
-	expirationTime int64
+	expirationTime time.Time
 }
 
 // FIFOCache 是FIFO算法实现的缓存
@@ -22,6 +22,9 @@ type FIFOCache struct {
 	doublyLinkedList *list.List // 链头表示最近使用
 
 	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
 }
 
 // New 创建指定最大容量的LRU缓存。
@@ -40,7 +43,7 @@ func New(maxBytes int64, callback cache.OnEliminated) *FIFOCache {
 func (c *FIFOCache) Get(key string) (value cache.Lengthable, ok bool) {
 	if elem, ok := c.hashmap[key]; ok {
 		if checkExpirationTime(elem.Value.(*entry).expirationTime) {
-			c.removeElement(elem)
+			c.expireElement(elem)
 			return nil, false
 		}
 		kv := elem.Value.(*entry)
@@ -50,7 +53,7 @@ func (c *FIFOCache) Get(key string) (value cache.Lengthable, ok bool) {
 }
 
 // Add 向缓存中添加指定key的value
-func (c *FIFOCache) Add(key string, value cache.Lengthable, expirationTime int64) {
+func (c *FIFOCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
 	kvSize := int64(len(key)) + int64(value.Len())
 	// cache 容量检查
 	for c.capacity != 0 && c.nowcap+kvSize > c.capacity {
@@ -89,7 +92,7 @@ func (c *FIFOCache) Contains(key string) (ok bool) {
 	if ok {
 		// 判断此值是否已经超时,如果超时则进行删除
 		if checkExpirationTime(e.Value.(*entry).expirationTime) {
-			c.removeElement(e)
+			c.expireElement(e)
 			return !ok
 		}
 	}
@@ -97,16 +100,16 @@ func (c *FIFOCache) Contains(key string) (ok bool) {
 }
 
 // Peek 在不更新的情况下返回键值(如果没有找到则返回false)，不更新缓存的状态
-func (c *FIFOCache) Peek(key string) (value cache.Lengthable, expirationTime int64, ok bool) {
+func (c *FIFOCache) Peek(key string) (value cache.Lengthable, expirationTime time.Time, ok bool) {
 	if e, ok := c.hashmap[key]; ok {
 		kv := e.Value.(*entry)
 		if checkExpirationTime(kv.expirationTime) {
-			c.removeElement(e)
-			return nil, 0, false
+			c.expireElement(e)
+			return nil, time.Time{}, false
 		}
 		return kv.value, kv.expirationTime, true
 	}
-	return nil, 0, ok
+	return nil, time.Time{}, ok
 }
 
 // removeOldest 淘汰一枚最近最不常用缓存
@@ -118,6 +121,7 @@ func (c *FIFOCache) RemoveOldest() {
 		delete(c.hashmap, k)                       // 移除映射
 		c.doublyLinkedList.Remove(tailElem)        // 移除缓存
 		c.nowcap -= int64(len(k)) + int64(v.Len()) // 更新占用内存情况
+		c.evictions++
 		// 移除后的善后处理
 		if c.callback != nil {
 			c.callback(k, v)
@@ -139,8 +143,41 @@ func (c *FIFOCache) Len() int {
 	return len(c.hashmap)
 }
 
-func checkExpirationTime(expirationTime int64) (ok bool) {
-	if 0 != expirationTime && expirationTime <= time.Now().UnixNano()/1e6 {
+// expireElement 删除一枚已过期的元素
+func (c *FIFOCache) expireElement(e *list.Element) {
+	c.expirations++
+	c.removeElement(e)
+}
+
+// Stats 返回缓存的淘汰次数与占用情况
+func (c *FIFOCache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.nowcap,
+		Entries:     int64(len(c.hashmap)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *FIFOCache) SweepExpired(n int) (checked, expired int) {
+	for _, e := range c.hashmap {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
 		return true
 	}
 	return false
@@ -14,13 +14,16 @@ type LFUCache struct {
 	fItems   map[int]*list.List //频率链表
 
 	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
 }
 
 type entry struct {
 	key            string
 	value          cache.Lengthable
 	freq           int
-	expirationTime int64
+	expirationTime time.Time
 }
 
 func New(capacity int64, callback cache.OnEliminated) *LFUCache {
@@ -42,7 +45,7 @@ func (c *LFUCache) Get(key string) (value cache.Lengthable, ok bool) {
 	}
 	if node, exist := c.kItems[key]; exist {
 		if checkExpirationTime(node.Value.(*entry).expirationTime) {
-			c.removeElement(node)
+			c.expireElement(node)
 			return nil, false
 		}
 		value = node.Value.(*entry).value
@@ -52,7 +55,7 @@ func (c *LFUCache) Get(key string) (value cache.Lengthable, ok bool) {
 	return nil, false
 }
 
-func (c *LFUCache) Add(key string, value cache.Lengthable, expirationTime int64) {
+func (c *LFUCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
 	if c.capacity <= 0 {
 		return
 	}
@@ -69,12 +72,12 @@ func (c *LFUCache) Add(key string, value cache.Lengthable, expirationTime int64)
 		return
 	}
 	//该键值不存在
-	for c.nowcap+kvSize > c.capacity { //挤出空间
-		c.RemoveOldest()
-	}
 	if kvSize > c.capacity { //新加的这个比总容量都要大了
 		return
 	}
+	for c.nowcap+kvSize > c.capacity { //挤出空间
+		c.RemoveOldest()
+	}
 
 	kv := &entry{key: key, value: value, freq: 1, expirationTime: expirationTime}
 	node := c.fItems[kv.freq].PushFront(kv)
@@ -98,7 +101,7 @@ func (c *LFUCache) Contains(key string) (ok bool) {
 	e, ok := c.kItems[key]
 	if ok {
 		if checkExpirationTime(e.Value.(*entry).expirationTime) {
-			c.removeElement(e)
+			c.expireElement(e)
 			return !ok
 		}
 	}
@@ -106,28 +109,36 @@ func (c *LFUCache) Contains(key string) (ok bool) {
 }
 
 // Peek 在不更新的情况下返回键值(如果没有找到则返回false)，不更新缓存的状态
-func (c *LFUCache) Peek(key string) (value cache.Lengthable, expirationTime int64, ok bool) {
+func (c *LFUCache) Peek(key string) (value cache.Lengthable, expirationTime time.Time, ok bool) {
 	if e, ok := c.kItems[key]; ok {
 		kv := e.Value.(*entry)
 		if checkExpirationTime(kv.expirationTime) {
-			c.removeElement(e)
-			return nil, 0, false
+			c.expireElement(e)
+			return nil, time.Time{}, false
 		}
 		return kv.value, kv.expirationTime, true
 	}
-	return nil, 0, ok
+	return nil, time.Time{}, ok
 }
 
 // removeOldest 从缓存中移除最老的项
 func (c *LFUCache) RemoveOldest() {
+	// 连续淘汰时minFre对应的链表可能已被淘汰空 向上找到实际的最小频率
+	for len(c.kItems) > 0 && (c.fItems[c.minFre] == nil || c.fItems[c.minFre].Len() == 0) {
+		c.minFre++
+	}
 	l := c.fItems[c.minFre] //找到最小使用频率的双向链表
-	tailnode := l.Back()    //找到双向链表的最后一个(最小频率使用且最长时间未使用)
+	if l == nil {
+		return
+	}
+	tailnode := l.Back() //找到双向链表的最后一个(最小频率使用且最长时间未使用)
 	if tailnode != nil {
 		kv := tailnode.Value.(*entry)                 //找到那条记录
 		delete(c.kItems, tailnode.Value.(*entry).key) //移除映射
 		l.Remove(tailnode)                            //在频率链表里也移除
 		kvSize := int64(len(kv.key) + kv.value.Len())
 		c.nowcap -= kvSize
+		c.evictions++
 		//移除后的善后处理
 		if c.callback != nil {
 			c.callback(kv.key, kv.value)
@@ -188,8 +199,41 @@ func (c *LFUCache) Len() int {
 	return len(c.kItems)
 }
 
-func checkExpirationTime(expirationTime int64) (ok bool) {
-	if 0 != expirationTime && expirationTime <= time.Now().UnixNano()/1e6 {
+// expireElement 删除一枚已过期的元素
+func (c *LFUCache) expireElement(e *list.Element) {
+	c.expirations++
+	c.removeElement(e)
+}
+
+// Stats 返回缓存的淘汰次数与占用情况
+func (c *LFUCache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.nowcap,
+		Entries:     int64(len(c.kItems)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *LFUCache) SweepExpired(n int) (checked, expired int) {
+	for _, e := range c.kItems {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
 		return true
 	}
 	return false
@@ -14,8 +14,8 @@ func (d String) Len() int {
 }
 
 // 生成当前时间 + 2秒
-func initTime() int64 {
-	return time.Now().UnixNano()/1e6 + 2000
+func initTime() time.Time {
+	return time.Now().Add(2 * time.Second)
 }
 
 func TestGet(t *testing.T) {
@@ -55,6 +55,18 @@ func TestRemoveoldest(t *testing.T) {
 	}
 }
 
+// 新条目需要连续淘汰多个旧条目 且最小频率的链表在中途被淘汰空
+func TestRemoveoldestSkipsEmptyFreq(t *testing.T) {
+	lfu := New(int64(20), nil)
+	lfu.Add("a", String("123456789"), initTime())
+	lfu.Get("a")
+	lfu.Add("b", String("123456789"), initTime())
+	lfu.Add("c", String("1234567890123456789"), initTime())
+	if !lfu.Contains("c") || lfu.Len() != 1 {
+		t.Fatalf("c should replace both a and b")
+	}
+}
+
 func TestOnEvicted(t *testing.T) {
 	initTime := initTime()
 	keys := make([]string, 0)
@@ -111,7 +123,7 @@ func TestExpirationTime(t *testing.T) {
 	initTime := initTime()
 	lfu := New(int64(100), nil)
 	lfu.Add("key1", String("1"), initTime)
-	lfu.Add("key2", String("2"), time.Now().UnixNano()/1e6+4000)
+	lfu.Add("key2", String("2"), time.Now().Add(4*time.Second))
 	lfu.Get("key2")
 	time.Sleep(2 * time.Second)
 	if _, ok := lfu.Get("key1"); ok {
@@ -121,3 +133,17 @@ func TestExpirationTime(t *testing.T) {
 		t.Fatal("func removeElement has something wrong")
 	}
 }
+
+func TestStats(t *testing.T) {
+	c := New(int64(10), nil)
+	c.Add("k1", String("1234"), initTime())
+	c.Add("k2", String("1234"), initTime())
+	c.Add("k3", String("12"), time.Now().Add(-time.Second))
+	if _, ok := c.Get("k3"); ok {
+		t.Fatalf("k3 should be expired")
+	}
+	stats := c.Stats()
+	if stats.Evictions != 1 || stats.Expirations != 1 || stats.Entries != 1 || stats.Bytes != 6 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -10,7 +10,7 @@ import (
 type entry struct {
 	key            string
 	value          cache.Lengthable
-	expirationTime int64
+	expirationTime time.Time
 }
 
 // LRUCache 是LRU算法实现的缓存
@@ -21,6 +21,9 @@ type LRUCache struct {
 	doublyLinkedList *list.List // 链头表示最近使用
 
 	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
 }
 
 // New 创建指定最大容量的LRU缓存。
@@ -39,7 +42,7 @@ func New(maxBytes int64, callback cache.OnEliminated) *LRUCache {
 func (c *LRUCache) Get(key string) (value cache.Lengthable, ok bool) {
 	if elem, ok := c.hashmap[key]; ok {
 		if checkExpirationTime(elem.Value.(*entry).expirationTime) {
-			c.removeElement(elem)
+			c.expireElement(elem)
 			return nil, false
 		}
 		c.doublyLinkedList.MoveToFront(elem)
@@ -50,7 +53,7 @@ func (c *LRUCache) Get(key string) (value cache.Lengthable, ok bool) {
 }
 
 // Add 向缓存中添加指定key的value
-func (c *LRUCache) Add(key string, value cache.Lengthable, expirationTime int64) {
+func (c *LRUCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
 	kvSize := int64(len(key)) + int64(value.Len())
 	// cache 容量检查
 	for c.capacity != 0 && c.nowcap+kvSize > c.capacity {
@@ -90,7 +93,7 @@ func (c *LRUCache) Contains(key string) (ok bool) {
 	if ok {
 		// 判断此值是否已经超时,如果超时则进行删除
 		if checkExpirationTime(e.Value.(*entry).expirationTime) {
-			c.removeElement(e)
+			c.expireElement(e)
 			return !ok
 		}
 	}
@@ -98,16 +101,16 @@ func (c *LRUCache) Contains(key string) (ok bool) {
 }
 
 // Peek 在不更新的情况下返回键值(如果没有找到则返回false)，不更新缓存的状态
-func (c *LRUCache) Peek(key string) (value cache.Lengthable, expirationTime int64, ok bool) {
+func (c *LRUCache) Peek(key string) (value cache.Lengthable, expirationTime time.Time, ok bool) {
 	if e, ok := c.hashmap[key]; ok {
 		kv := e.Value.(*entry)
 		if checkExpirationTime(kv.expirationTime) {
-			c.removeElement(e)
-			return nil, 0, false
+			c.expireElement(e)
+			return nil, time.Time{}, false
 		}
 		return kv.value, kv.expirationTime, true
 	}
-	return nil, 0, ok
+	return nil, time.Time{}, ok
 }
 
 // removeOldest 淘汰一枚最近最不常用缓存
@@ -119,6 +122,7 @@ func (c *LRUCache) RemoveOldest() {
 		delete(c.hashmap, k)                       // 移除映射
 		c.doublyLinkedList.Remove(tailElem)        // 移除缓存
 		c.nowcap -= int64(len(k)) + int64(v.Len()) // 更新占用内存情况
+		c.evictions++
 		// 移除后的善后处理
 		if c.callback != nil {
 			c.callback(k, v)
@@ -141,8 +145,41 @@ func (c *LRUCache) Len() int {
 	return len(c.hashmap)
 }
 
-func checkExpirationTime(expirationTime int64) (ok bool) {
-	if 0 != expirationTime && expirationTime <= time.Now().UnixNano()/1e6 {
+// expireElement 删除一枚已过期的元素
+func (c *LRUCache) expireElement(e *list.Element) {
+	c.expirations++
+	c.removeElement(e)
+}
+
+// Stats 返回缓存的淘汰次数与占用情况
+func (c *LRUCache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.nowcap,
+		Entries:     int64(len(c.hashmap)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *LRUCache) SweepExpired(n int) (checked, expired int) {
+	for _, e := range c.hashmap {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
 		return true
 	}
 	return false
@@ -14,8 +14,8 @@ func (d String) Len() int {
 }
 
 // 生成当前时间 + 2秒
-func initTime() int64 {
-	return time.Now().UnixNano()/1e6 + 2000
+func initTime() time.Time {
+	return time.Now().Add(2 * time.Second)
 }
 
 func TestGet(t *testing.T) {
@@ -110,3 +110,17 @@ func TestExpirationTime(t *testing.T) {
 		t.Fatal("expected key expired but got key")
 	}
 }
+
+func TestStats(t *testing.T) {
+	c := New(int64(10), nil)
+	c.Add("k1", String("1234"), initTime())
+	c.Add("k2", String("1234"), initTime())
+	c.Add("k3", String("12"), time.Now().Add(-time.Second))
+	if _, ok := c.Get("k3"); ok {
+		t.Fatalf("k3 should be expired")
+	}
+	stats := c.Stats()
+	if stats.Evictions != 1 || stats.Expirations != 1 || stats.Entries != 1 || stats.Bytes != 6 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
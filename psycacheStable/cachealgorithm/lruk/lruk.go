@@ -1,96 +1,377 @@
 package lruk
 
+// lruk 实现LRU-K缓存 参考O'Neil等人的论文
+// 每个key记录最近K次访问的逻辑时刻 淘汰时选择倒数第K次访问最早(即backward K-distance最大)的条目
+// 访问不足K次的条目K-distance视为无穷大 最先被淘汰 它们之间按最近一次访问的先后淘汰 因此一次性的扫描无法挤走热点
+// 与上一次访问间隔不超过相关访问周期的访问视为同一次访问 不会让条目的访问次数虚高
+// 刚被访问过、仍处于相关访问周期内的条目暂不参与淘汰
+// 被淘汰的key的访问记录会保留一段时间 再次写入时可以接着累计 这部分记录不保存value 且数量有上限
+
 import (
+	"container/heap"
+	"container/list"
 	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
-	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lru"
+	"time"
 )
 
-// LRUKCache 是LRU算法实现的缓存
-// 使用两个LRU搭配上historyVisited表记录被访问次数实现LRU-K算法
+const defaultHistoryRatio = 1 // 默认保留访问记录的已淘汰key数与常驻条目数之比
+
+// history 是一个key的访问记录
+type history struct {
+	key      string
+	refs     []uint64      // 最近K次非相关访问的逻辑时刻 refs[0]是最近一次 0代表没有
+	last     time.Time     // 最近一次访问的时刻 包括相关访问
+	lastTick uint64        // 最近一次访问的逻辑时刻 包括相关访问
+	missed   bool          // 最近一次访问是未命中的Get 紧随其后的Add不再重复计数
+	elem     *list.Element // key未常驻时在ghosts中的位置
+}
+
+// entry 是常驻的缓存条目
+type entry struct {
+	key            string
+	value          cache.Lengthable
+	expirationTime time.Time
+	hist           *history
+	index          int // 在淘汰堆中的下标
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+// evictionHeap 按淘汰的先后排列常驻条目 堆顶是backward K-distance最大的条目
+type evictionHeap []*entry
+
+func (h evictionHeap) Len() int { return len(h) }
+
+func (h evictionHeap) Less(i, j int) bool {
+	a, b := h[i].hist.refs, h[j].hist.refs
+	k := len(a) - 1
+	if a[k] != b[k] {
+		return a[k] < b[k]
+	}
+	return a[0] < b[0]
+}
+
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *evictionHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *evictionHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// LRUKCache 是LRU-K算法实现的缓存
 type LRUKCache struct {
-	capacity       int64 // Cache 最大容量(Byte)
-	k              int   //进入缓存队列的次数标准
-	datalru        *lru.LRUCache
-	historylru     *lru.LRUCache
-	historyVisited map[string]int
+	capacity     int64         // Cache 最大容量(Byte)
+	nowcap       int64         // Cache 当前容量(Byte)
+	k            int           // 计算K-distance时使用的访问次数
+	crp          time.Duration // 相关访问周期 为0时每次访问都单独计数
+	historyRatio float64       // 保留访问记录的已淘汰key数与常驻条目数之比
+
+	tick      uint64 // 逻辑时钟 每次非相关访问加一
+	entries   map[string]*entry
+	heap      evictionHeap
+	histories map[string]*history // 常驻与已淘汰key的访问记录
+	ghosts    *list.List          // 已淘汰key的访问记录 链头表示最近访问
+
+	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
+}
+
+// Option 配置 LRUKCache
+type Option func(*LRUKCache)
+
+// WithCorrelatedReferencePeriod 设置相关访问周期
+// 与上一次访问间隔不超过d的访问不增加访问次数 刚被访问过d以内的条目不参与淘汰
+func WithCorrelatedReferencePeriod(d time.Duration) Option {
+	return func(c *LRUKCache) {
+		if d > 0 {
+			c.crp = d
+		}
+	}
 }
 
-// New 创建指定最大容量的LRU缓存。
+// WithHistoryRatio 设置保留访问记录的已淘汰key数与常驻条目数之比 必须大于0 默认为1
+func WithHistoryRatio(ratio float64) Option {
+	return func(c *LRUKCache) {
+		if ratio > 0 {
+			c.historyRatio = ratio
+		}
+	}
+}
+
+// New 创建指定最大容量的LRU-K缓存 visitedNum即K 小于1时按1处理
 // 当maxBytes为0时，代表cache无内存限制，无限存放。
-func New(maxBytes int64, visitedNum int, callback cache.OnEliminated) *LRUKCache {
-	datalru := lru.New(maxBytes, callback)
-	historylru := lru.New(maxBytes, callback)
-	return &LRUKCache{
-		capacity:       maxBytes,
-		k:              visitedNum,
-		datalru:        datalru,
-		historylru:     historylru,
-		historyVisited: make(map[string]int),
+func New(maxBytes int64, visitedNum int, callback cache.OnEliminated, opts ...Option) *LRUKCache {
+	if visitedNum < 1 {
+		visitedNum = 1
+	}
+	c := &LRUKCache{
+		capacity:     maxBytes,
+		k:            visitedNum,
+		historyRatio: defaultHistoryRatio,
+		entries:      make(map[string]*entry),
+		histories:    make(map[string]*history),
+		ghosts:       list.New(),
+		callback:     callback,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// Get 从缓存获取对应key的value。
-// ok 指明查询结果 false代表查无此key
+// Get 从缓存获取对应key的value 无论是否命中都会记录一次访问
+// ok 指明查询结果 false代表查无此key 只有访问记录的key同样视为未命中
 func (c *LRUKCache) Get(key string) (value cache.Lengthable, ok bool) {
-	if value, ok = c.datalru.Get(key); ok { //能在数据缓存中找到
-		c.historyVisited[key]++
-		return value, ok
-	} else if c.historylru.Contains(key) && c.historyVisited[key] == c.k-1 { //不能在数据缓存中找到，但在历史缓存中找到且达到了访问次数阈值
-		value, expiretionTime, _ := c.historylru.Peek(key)
-		c.datalru.Add(key, value, expiretionTime)
-		c.historylru.Remove(key)
-		c.historyVisited[key]++
-		return value, true
-	} else if c.historylru.Contains(key) && c.historyVisited[key] < c.k-1 { //不能在数据缓存中找到，但在历史缓存中找到但未达到访问次数阈值
-		value, _ := c.historylru.Get(key)
-		c.historyVisited[key]++
-		return value, true
-	} else if !c.historylru.Contains(key) { //历史缓存中也找不到
-		delete(c.historyVisited, key)
+	e, ok := c.entries[key]
+	if ok && checkExpirationTime(e.expirationTime) {
+		c.expireEntry(e)
+		ok = false
+	}
+	if !ok {
+		h := c.ghostHistory(key)
+		c.reference(h)
+		h.missed = true
+		c.trimHistory()
 		return nil, false
 	}
-	return nil, false
+	if c.reference(e.hist) {
+		heap.Fix(&c.heap, e.index)
+	}
+	return e.value, true
 }
 
 // Add 向缓存中添加指定key的value
-func (c *LRUKCache) Add(key string, value cache.Lengthable, expirationTime int64) {
-	if c.datalru.Contains(key) { //能在数据缓存中找到，更新一下信息
-		c.datalru.Add(key, value, expirationTime)
-		c.historyVisited[key]++
-	} else if c.historylru.Contains(key) && c.historyVisited[key] == c.k-1 { //不能在数据缓存中找到，但在历史缓存中找到且达到了访问次数阈值
-		c.datalru.Add(key, value, expirationTime)
-		c.historylru.Remove(key)
-		c.historyVisited[key]++
-	} else if c.historylru.Contains(key) && c.historyVisited[key] < c.k-1 { //不能在数据缓存中找到，但在历史缓存中找到但未达到访问次数阈值
-		c.historylru.Add(key, value, expirationTime)
-		c.historyVisited[key]++
-	} else { //无法在历史缓存中找到
-		c.historylru.Add(key, value, expirationTime)
-		c.historyVisited[key] = 1
-	}
-}
-
-// Remove 从缓存中移除提供的键
+// 紧随未命中的Get之后写入同一个key时 视为与该次Get是同一次访问
+func (c *LRUKCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
+	kvSize := int64(len(key)) + int64(value.Len())
+	if c.capacity != 0 && kvSize > c.capacity { //新加的这个比总容量都要大了
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		// 更新缓存key值
+		c.makeRoom(kvSize-e.size(), e)
+		c.nowcap += kvSize - e.size()
+		e.value = value
+		e.expirationTime = expirationTime
+		if c.reference(e.hist) {
+			heap.Fix(&c.heap, e.index)
+		}
+		return
+	}
+	h := c.ghostHistory(key)
+	if h.missed {
+		h.missed = false
+	} else {
+		c.reference(h)
+	}
+	c.ghosts.Remove(h.elem)
+	h.elem = nil
+	c.makeRoom(kvSize, nil)
+	e := &entry{key: key, value: value, expirationTime: expirationTime, hist: h}
+	c.entries[key] = e
+	heap.Push(&c.heap, e)
+	c.nowcap += kvSize
+	c.trimHistory()
+}
+
+// Remove 从缓存中移除提供的键 该key的访问记录一并删除
 func (c *LRUKCache) Remove(key string) (ok bool) {
-	if c.datalru.Contains(key) {
-		ok := c.datalru.Remove(key)
-		delete(c.historyVisited, key)
-		return ok
+	if h, exist := c.histories[key]; exist && h.elem != nil {
+		c.removeHistory(h)
 	}
-	if c.historylru.Contains(key) {
-		ok := c.historylru.Remove(key)
-		delete(c.historyVisited, key)
-		return ok
+	if e, exist := c.entries[key]; exist {
+		c.removeEntry(e)
+		delete(c.histories, key)
+		return exist
 	}
-	delete(c.historyVisited, key)
 	return false
 }
 
 // Contains 检查某个键是否在缓存中，但不更新缓存的状态
 func (c *LRUKCache) Contains(key string) (ok bool) {
-	if c.datalru.Contains(key) || c.historylru.Contains(key) {
+	e, ok := c.entries[key]
+	if ok {
+		// 判断此值是否已经超时,如果超时则进行删除
+		if checkExpirationTime(e.expirationTime) {
+			c.expireEntry(e)
+			return !ok
+		}
+	}
+	return ok
+}
+
+// Len 获取缓存的长度 不包括只有访问记录的key
+func (c *LRUKCache) Len() int {
+	return len(c.entries)
+}
+
+// reference 记录一次访问 返回该条目在淘汰堆中的次序是否可能改变
+func (c *LRUKCache) reference(h *history) bool {
+	now := time.Now()
+	h.missed = false
+	if c.crp > 0 && h.refs[0] != 0 && now.Sub(h.last) <= c.crp {
+		// 相关访问只更新最近访问时刻
+		h.last = now
+		h.lastTick = c.tick
+		return false
+	}
+	c.tick++
+	// 将相关访问周期从历史中扣除 使K-distance只反映非相关访问之间的间隔
+	correlated := h.lastTick - h.refs[0]
+	for i := len(h.refs) - 1; i > 0; i-- {
+		if h.refs[i-1] != 0 {
+			h.refs[i] = h.refs[i-1] + correlated
+		}
+	}
+	h.refs[0] = c.tick
+	h.last = now
+	h.lastTick = c.tick
+	return true
+}
+
+// ghostHistory 返回key的访问记录 不存在时新建一条放在ghosts链头
+func (c *LRUKCache) ghostHistory(key string) *history {
+	h, ok := c.histories[key]
+	if !ok {
+		h = &history{key: key, refs: make([]uint64, c.k)}
+		c.histories[key] = h
+	}
+	if h.elem == nil {
+		h.elem = c.ghosts.PushFront(h)
+	} else {
+		c.ghosts.MoveToFront(h.elem)
+	}
+	return h
+}
+
+// makeRoom 淘汰常驻条目直到能再放入size大小的数据 exclude不会被淘汰
+// 优先淘汰已过相关访问周期的条目中backward K-distance最大的 都在周期内时直接淘汰堆顶
+func (c *LRUKCache) makeRoom(size int64, exclude *entry) {
+	if c.capacity == 0 {
+		return
+	}
+	now := time.Now()
+	var skipped []*entry
+	for c.nowcap+size > c.capacity && c.heap.Len() > 0 {
+		victim := heap.Pop(&c.heap).(*entry)
+		if victim == exclude || (c.crp > 0 && now.Sub(victim.hist.last) <= c.crp) {
+			skipped = append(skipped, victim)
+			continue
+		}
+		c.evictEntry(victim)
+	}
+	for c.nowcap+size > c.capacity && len(skipped) > 0 {
+		// 其余条目都已淘汰 只能淘汰仍在相关访问周期内的条目
+		victim := skipped[0]
+		skipped = skipped[1:]
+		if victim == exclude {
+			heap.Push(&c.heap, victim)
+			continue
+		}
+		c.evictEntry(victim)
+	}
+	for _, e := range skipped {
+		heap.Push(&c.heap, e)
+	}
+}
+
+// trimHistory 丢弃最久未访问的已淘汰key的访问记录 使其数量不超过常驻条目数的historyRatio倍
+func (c *LRUKCache) trimHistory() {
+	limit := int(float64(len(c.entries)) * c.historyRatio)
+	if limit < 1 {
+		limit = 1
+	}
+	for c.ghosts.Len() > limit {
+		c.removeHistory(c.ghosts.Back().Value.(*history))
+	}
+}
+
+// removeHistory 删除一个已淘汰key的访问记录
+func (c *LRUKCache) removeHistory(h *history) {
+	c.ghosts.Remove(h.elem)
+	h.elem = nil
+	delete(c.histories, h.key)
+}
+
+// removeEntry 删除一枚常驻条目 其访问记录留在histories中由调用方处理
+func (c *LRUKCache) removeEntry(e *entry) {
+	if e.index >= 0 {
+		heap.Remove(&c.heap, e.index)
+	}
+	delete(c.entries, e.key)
+	c.nowcap -= e.size()
+	if c.callback != nil {
+		c.callback(e.key, e.value)
+	}
+}
+
+// retire 删除一枚常驻条目 保留其访问记录
+func (c *LRUKCache) retire(e *entry) {
+	c.removeEntry(e)
+	e.hist.elem = c.ghosts.PushFront(e.hist)
+}
+
+// evictEntry 因容量不足淘汰一枚条目 调用前条目已从淘汰堆中取出
+func (c *LRUKCache) evictEntry(e *entry) {
+	c.evictions++
+	c.retire(e)
+}
+
+// expireEntry 删除一枚已过期的条目
+func (c *LRUKCache) expireEntry(e *entry) {
+	c.expirations++
+	c.retire(e)
+	c.trimHistory()
+}
+
+// Stats 返回缓存的淘汰次数与占用情况 不包括只有访问记录的key
+func (c *LRUKCache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.nowcap,
+		Entries:     int64(len(c.entries)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *LRUKCache) SweepExpired(n int) (checked, expired int) {
+	for _, e := range c.entries {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.expirationTime) {
+			c.expireEntry(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
 		return true
 	}
-	delete(c.historyVisited, key)
 	return false
 }
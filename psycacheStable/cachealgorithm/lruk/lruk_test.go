@@ -3,6 +3,7 @@ package lruk
 import (
 	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -14,8 +15,8 @@ func (d String) Len() int {
 }
 
 // 生成当前时间 + 2秒
-func initTime() int64 {
-	return time.Now().UnixNano()/1e6 + 2000
+func initTime() time.Time {
+	return time.Now().Add(2 * time.Second)
 }
 
 func TestGet(t *testing.T) {
@@ -32,10 +33,9 @@ func TestGet(t *testing.T) {
 	if _, ok := lruk.Get("key1"); ok {
 		t.Fatalf("key1 should be expired")
 	}
-	if _, ok := lruk.historyVisited["key1"]; ok {
-		t.Fatalf("key1 should be expired and should not be found in map")
+	if lruk.Len() != 0 || lruk.Stats().Expirations != 1 {
+		t.Fatalf("key1 should be removed after expiration")
 	}
-
 }
 
 func TestOnEvicted(t *testing.T) {
@@ -65,9 +65,12 @@ func TestAdd(t *testing.T) {
 	lruk.Add("key1", String("111"), initTime)
 	lruk.Add("key1", String("11"), initTime)
 	lruk.Add("key2", String("2"), initTime)
-	if lruk.datalru.Len() != 1 || lruk.historylru.Len() != 1 {
+	if lruk.Len() != 2 || lruk.Stats().Bytes != 11 {
 		t.Fatal("func Add has something wrong")
 	}
+	if refs := lruk.histories["key1"].refs; refs[2] == 0 {
+		t.Fatalf("key1 should have 3 references, got %v", refs)
+	}
 }
 
 func TestRemove(t *testing.T) {
@@ -76,12 +79,23 @@ func TestRemove(t *testing.T) {
 	lruk.Add("key1", String("1"), initTime)
 	lruk.Add("key2", String("2"), initTime)
 	lruk.Remove("key2")
+	if _, ok := lruk.histories["key2"]; ok {
+		t.Fatal("history of key2 should be removed")
+	}
 
+	// 未命中的Get重新记录一次访问 但只留下已淘汰key的访问记录
 	if _, ok := lruk.Get("key2"); ok {
 		t.Fatal("expected nonexist but got key2")
 	}
-	if _, ok := lruk.historyVisited["key2"]; ok {
-		t.Fatal("key2 should not exist but got")
+	if h, ok := lruk.histories["key2"]; !ok || h.elem == nil || lruk.Contains("key2") {
+		t.Fatal("missed Get should only leave a ghost history of key2")
+	}
+	// 访问记录的数量不超过常驻条目数的historyRatio倍
+	for i := 0; i < 10; i++ {
+		lruk.Get("ghost" + strconv.Itoa(i))
+	}
+	if lruk.ghosts.Len() != 1 || len(lruk.histories) != 2 {
+		t.Fatalf("Actual: %d ghosts %d histories\tExpect: 1 ghosts 2 histories", lruk.ghosts.Len(), len(lruk.histories))
 	}
 }
 
@@ -90,7 +104,67 @@ func TestContains(t *testing.T) {
 	lruk := New(int64(6), 2, nil)
 	lruk.Add("key", String("1"), initTime)
 
-	if !lruk.Contains("key") || lruk.historyVisited["key"] != 1 {
+	if !lruk.Contains("key") || lruk.histories["key"].refs[0] == 0 {
 		t.Fatal("expected got key but nonexist")
 	}
 }
+
+// 被淘汰的key只留下访问记录 Get不会返回它的value
+func TestHistoryIsNotHit(t *testing.T) {
+	lruk := New(int64(10), 2, nil)
+	lruk.Add("key1", String("123456"), time.Time{})
+	lruk.Add("key2", String("123456"), time.Time{})
+	if _, ok := lruk.Get("key1"); ok {
+		t.Fatal("key1 was evicted and should miss")
+	}
+	if _, ok := lruk.histories["key1"]; !ok {
+		t.Fatal("key1 should keep its history")
+	}
+}
+
+// 访问满K次的热点不会被只访问一次的扫描挤走
+func TestKDistanceEviction(t *testing.T) {
+	lruk := New(int64(100), 2, nil)
+	for i := 0; i < 5; i++ {
+		key := "hot" + strconv.Itoa(i)
+		if _, ok := lruk.Get(key); !ok {
+			lruk.Add(key, String("12345"), time.Time{})
+		}
+		lruk.Get(key)
+	}
+	for i := 0; i < 1000; i++ {
+		key := "scan" + strconv.Itoa(i)
+		if _, ok := lruk.Get(key); !ok {
+			lruk.Add(key, String("12345"), time.Time{})
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if !lruk.Contains("hot" + strconv.Itoa(i)) {
+			t.Fatalf("hot%d should survive the scan", i)
+		}
+	}
+	if stats := lruk.Stats(); stats.Bytes > 100 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if len(lruk.histories) > 2*lruk.Len() {
+		t.Fatalf("history should be bounded, got %d for %d entries", len(lruk.histories), lruk.Len())
+	}
+}
+
+// 相关访问周期内的多次访问只计一次
+func TestCorrelatedReference(t *testing.T) {
+	lruk := New(int64(20), 2, nil, WithCorrelatedReferencePeriod(time.Hour))
+	lruk.Add("burst", String("12345"), time.Time{})
+	lruk.Get("burst")
+	lruk.Get("burst")
+	if refs := lruk.histories["burst"].refs; refs[1] != 0 {
+		t.Fatalf("correlated references should count once, got %v", refs)
+	}
+
+	lruk = New(int64(20), 2, nil)
+	lruk.Add("key", String("12345"), time.Time{})
+	lruk.Get("key")
+	if refs := lruk.histories["key"].refs; refs[1] == 0 {
+		t.Fatalf("references should count twice without a correlated period, got %v", refs)
+	}
+}
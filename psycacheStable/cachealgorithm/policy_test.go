@@ -0,0 +1,141 @@
+package cachealgorithm_test
+
+// 对所有缓存算法共有的行为做表驱动测试 各算法特有的行为在各自的包中测试
+
+import (
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/arc"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/clock"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/clockpro"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/fifo"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lfu"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lru"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lruk"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/s3fifo"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/tinylfu"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/twoQ"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// policy 是各缓存算法共有的方法
+type policy interface {
+	Get(key string) (cache.Lengthable, bool)
+	Add(key string, value cache.Lengthable, expirationTime time.Time)
+	cache.StatsReporter
+	cache.Sweeper
+}
+
+// policies 列出所有缓存算法的构造函数
+var policies = []struct {
+	name string
+	new  func(maxBytes int64) policy
+}{
+	{"fifo", func(maxBytes int64) policy { return fifo.New(maxBytes, nil) }},
+	{"lru", func(maxBytes int64) policy { return lru.New(maxBytes, nil) }},
+	{"lfu", func(maxBytes int64) policy { return lfu.New(maxBytes, nil) }},
+	{"lruk", func(maxBytes int64) policy { return lruk.New(maxBytes, 2, nil) }},
+	{"twoQ", func(maxBytes int64) policy { return twoQ.New(maxBytes, nil) }},
+	{"tinylfu", func(maxBytes int64) policy { return tinylfu.New(maxBytes, nil) }},
+	{"arc", func(maxBytes int64) policy { return arc.New(maxBytes, nil) }},
+	{"clock", func(maxBytes int64) policy { return clock.New(maxBytes, nil) }},
+	{"clockpro", func(maxBytes int64) policy { return clockpro.New(maxBytes, nil) }},
+	{"s3fifo", func(maxBytes int64) policy { return s3fifo.New(maxBytes, nil) }},
+}
+
+func TestSweepExpired(t *testing.T) {
+	for _, p := range policies {
+		t.Run(p.name, func(t *testing.T) {
+			c := p.new(1000)
+			for i := 0; i < 10; i++ {
+				c.Add("expired"+strconv.Itoa(i), String("v"), time.Now().Add(-time.Second))
+			}
+			for i := 0; i < 5; i++ {
+				c.Add("alive"+strconv.Itoa(i), String("v"), time.Now().Add(2*time.Second))
+			}
+			var expired int
+			for round := 0; round < 100 && expired < 10; round++ {
+				_, n := c.SweepExpired(4)
+				expired += n
+			}
+			if stats := c.Stats(); expired != 10 || stats.Expirations != 10 || stats.Entries != 5 {
+				t.Fatalf("Actual: %d expired %+v\tExpect: 10 expired 5 entries", expired, stats)
+			}
+			if checked, n := c.SweepExpired(10); checked != 5 || n != 0 {
+				t.Fatalf("Actual: %d checked %d expired\tExpect: 5 checked 0 expired", checked, n)
+			}
+		})
+	}
+}
+
+// lockedLRU 用互斥锁保护lru 与psycache中使用lru的方式相同
+type lockedLRU struct {
+	mu sync.Mutex
+	*lru.LRUCache
+}
+
+func (l *lockedLRU) Get(key string) (cache.Lengthable, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.LRUCache.Get(key)
+}
+
+func (l *lockedLRU) Add(key string, value cache.Lengthable, expirationTime time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.LRUCache.Add(key, value, expirationTime)
+}
+
+// benchmarkGetParallel 多个goroutine按Zipf分布并发读取 未命中时写入 绝大多数访问是命中的读操作
+func benchmarkGetParallel(b *testing.B, c policy) {
+	keys := make([]string, 5000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	for _, key := range keys[:4096] {
+		c.Add(key, String("01234567"), time.Time{})
+	}
+	var seed int64
+	var mu sync.Mutex
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		mu.Lock()
+		seed++
+		r := rand.New(rand.NewSource(seed))
+		mu.Unlock()
+		zipf := rand.NewZipf(r, 1.01, 1, uint64(len(keys)-1))
+		for pb.Next() {
+			key := keys[zipf.Uint64()]
+			if _, ok := c.Get(key); !ok {
+				c.Add(key, String("01234567"), time.Time{})
+			}
+		}
+	})
+}
+
+// BenchmarkGetParallel 对比读取时只需读锁的算法与用互斥锁保护的lru
+func BenchmarkGetParallel(b *testing.B) {
+	const capacity = 4096 * 16
+	for _, c := range []struct {
+		name string
+		new  func() policy
+	}{
+		{"clock", func() policy { return clock.New(capacity, nil) }},
+		{"clockpro", func() policy { return clockpro.New(capacity, nil) }},
+		{"s3fifo", func() policy { return s3fifo.New(capacity, nil) }},
+		{"lru", func() policy { return &lockedLRU{LRUCache: lru.New(capacity, nil)} }},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			benchmarkGetParallel(b, c.new())
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package s3fifo
+
+// s3fifo 实现S3-FIFO缓存 参考Yang等人在SOSP'23发表的论文
+// 新写入的条目进入占总容量10%的小队列S 大部分只被访问一次的条目在S中就被淘汰 只在幽灵队列G中留下key
+// 在S中被访问过的条目以及G中记录过的key进入主队列M
+// M淘汰时 访问计数不为0的条目计数减一后重新放回队头 相当于多轮的CLOCK
+// 三个队列都是FIFO 读路径只增加条目的访问计数(上限为3) 不移动任何节点 S3FIFOCache 自身是并发安全的
+
+import (
+	"container/list"
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	smallPercent = 10 // S占总容量的百分比
+	maxFreq      = 3  // 访问计数的上限
+)
+
+// entry 定义队列节点所存储的对象
+type entry struct {
+	key            string
+	value          cache.Lengthable
+	expirationTime time.Time
+	freq           atomic.Int32 // 访问计数 读路径在读锁下增加
+	queue          *queue       // 所在的队列
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+// touch 访问计数加一 达到上限后不再写入 避免多核争抢同一缓存行
+func (e *entry) touch() {
+	for {
+		freq := e.freq.Load()
+		if freq >= maxFreq || e.freq.CompareAndSwap(freq, freq+1) {
+			return
+		}
+	}
+}
+
+// queue 是一个FIFO队列 链头表示最近加入
+type queue struct {
+	list  *list.List
+	bytes int64 // 该队列占用的内存大小(Byte)
+}
+
+func newQueue() *queue {
+	return &queue{list: list.New()}
+}
+
+// S3FIFOCache 是S3-FIFO算法实现的缓存
+type S3FIFOCache struct {
+	mu       sync.RWMutex
+	capacity int64 // Cache 最大容量(Byte)
+	smallCap int64 // S的最大容量(Byte)
+
+	small   *queue     // 新写入的条目
+	main    *queue     // 被证明会再次访问的条目
+	ghost   *list.List // 从S淘汰的key 记录数不超过M中的条目数
+	hashmap map[string]*list.Element
+	ghosts  map[string]*list.Element
+
+	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
+}
+
+// New 创建指定最大容量的S3-FIFO缓存
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func New(maxBytes int64, callback cache.OnEliminated) *S3FIFOCache {
+	return &S3FIFOCache{
+		capacity: maxBytes,
+		smallCap: maxBytes * smallPercent / 100,
+		small:    newQueue(),
+		main:     newQueue(),
+		ghost:    list.New(),
+		hashmap:  make(map[string]*list.Element),
+		ghosts:   make(map[string]*list.Element),
+		callback: callback,
+	}
+}
+
+// Get 从缓存获取对应key的value 命中时只增加访问计数
+// ok 指明查询结果 false代表查无此key
+func (c *S3FIFOCache) Get(key string) (value cache.Lengthable, ok bool) {
+	c.mu.RLock()
+	elem, ok := c.hashmap[key]
+	if !ok {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	kv := elem.Value.(*entry)
+	if checkExpirationTime(kv.expirationTime) {
+		c.mu.RUnlock()
+		c.expire(key)
+		return nil, false
+	}
+	kv.touch()
+	value = kv.value
+	c.mu.RUnlock()
+	return value, true
+}
+
+// Add 向缓存中添加指定key的value
+// 新的key进入S G中记录过的key直接进入M
+func (c *S3FIFOCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
+	kvSize := int64(len(key)) + int64(value.Len())
+	if c.capacity != 0 && kvSize > c.capacity { //新加的这个比总容量都要大了
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.hashmap[key]; ok {
+		// 更新缓存key值 视为一次访问
+		kv := elem.Value.(*entry)
+		kv.queue.bytes += kvSize - kv.size()
+		kv.value = value
+		kv.expirationTime = expirationTime
+		kv.touch()
+		c.makeRoom(0)
+		return
+	}
+	to := c.small
+	if g, ok := c.ghosts[key]; ok {
+		c.removeGhost(g)
+		to = c.main
+	}
+	c.makeRoom(kvSize)
+	kv := &entry{key: key, value: value, expirationTime: expirationTime}
+	c.push(kv, to)
+}
+
+// Remove 从缓存中移除提供的键 该key在G中的记录一并删除
+func (c *S3FIFOCache) Remove(key string) (ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g, exist := c.ghosts[key]; exist {
+		c.removeGhost(g)
+	}
+	if e, exist := c.hashmap[key]; exist {
+		c.removeElement(e)
+		return exist
+	}
+	return false
+}
+
+// Contains 检查某个键是否在缓存中，但不更新缓存的状态
+func (c *S3FIFOCache) Contains(key string) (ok bool) {
+	c.mu.RLock()
+	e, ok := c.hashmap[key]
+	expired := ok && checkExpirationTime(e.Value.(*entry).expirationTime)
+	c.mu.RUnlock()
+	if expired {
+		// 判断此值是否已经超时,如果超时则进行删除
+		c.expire(key)
+		return false
+	}
+	return ok
+}
+
+// Len 获取缓存的长度 不包括G中的key
+func (c *S3FIFOCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.hashmap)
+}
+
+// makeRoom 淘汰条目直到能再放入size大小的条目
+// S超过其份额时从S淘汰 否则从M淘汰
+func (c *S3FIFOCache) makeRoom(size int64) {
+	if c.capacity == 0 {
+		return
+	}
+	for c.small.bytes+c.main.bytes+size > c.capacity {
+		if c.small.list.Len() > 0 && (c.small.bytes > c.smallCap || c.main.list.Len() == 0) {
+			c.evictSmall()
+		} else {
+			c.evictMain()
+		}
+	}
+}
+
+// evictSmall 处理S队尾的条目 被访问过的移入M 否则淘汰并在G中记下key
+func (c *S3FIFOCache) evictSmall() {
+	elem := c.small.list.Back()
+	kv := elem.Value.(*entry)
+	if kv.freq.Load() > 0 {
+		c.unlink(elem)
+		kv.freq.Store(0)
+		c.push(kv, c.main)
+		return
+	}
+	c.evictions++
+	c.removeElement(elem)
+	c.ghosts[kv.key] = c.ghost.PushFront(kv.key)
+	limit := max(1, c.main.list.Len())
+	for c.ghost.Len() > limit {
+		c.removeGhost(c.ghost.Back())
+	}
+}
+
+// evictMain 处理M队尾的条目 访问计数不为0的计数减一后放回队头 否则淘汰
+func (c *S3FIFOCache) evictMain() {
+	elem := c.main.list.Back()
+	kv := elem.Value.(*entry)
+	if freq := kv.freq.Load(); freq > 0 {
+		kv.freq.Store(freq - 1)
+		c.main.list.MoveToFront(elem)
+		return
+	}
+	c.evictions++
+	c.removeElement(elem)
+}
+
+// push 将条目放入目标队列的队头
+func (c *S3FIFOCache) push(kv *entry, to *queue) {
+	kv.queue = to
+	c.hashmap[kv.key] = to.list.PushFront(kv)
+	to.bytes += kv.size()
+}
+
+// unlink 将条目移出所在的队列
+func (c *S3FIFOCache) unlink(e *list.Element) {
+	kv := e.Value.(*entry)
+	kv.queue.list.Remove(e)
+	kv.queue.bytes -= kv.size()
+	delete(c.hashmap, kv.key)
+}
+
+// removeGhost 删除G中的一个key
+func (c *S3FIFOCache) removeGhost(e *list.Element) {
+	c.ghost.Remove(e)
+	delete(c.ghosts, e.Value.(string))
+}
+
+// removeElement 删除一枚指定的元素
+func (c *S3FIFOCache) removeElement(e *list.Element) {
+	c.unlink(e)
+	if c.callback != nil {
+		kv := e.Value.(*entry)
+		c.callback(kv.key, kv.value)
+	}
+}
+
+// expire 在写锁下重新检查并删除已过期的key 获取写锁期间key可能已被更新或删除 过期的条目不记入G
+func (c *S3FIFOCache) expire(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.hashmap[key]; ok && checkExpirationTime(e.Value.(*entry).expirationTime) {
+		c.expirations++
+		c.removeElement(e)
+	}
+}
+
+// Stats 返回缓存的淘汰次数与占用情况 不包括G中的key
+func (c *S3FIFOCache) Stats() cache.Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.small.bytes + c.main.bytes,
+		Entries:     int64(len(c.hashmap)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *S3FIFOCache) SweepExpired(n int) (checked, expired int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.hashmap {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expirations++
+			c.removeElement(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package s3fifo
+
+import (
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// 生成当前时间 + 2秒
+func initTime() time.Time {
+	return time.Now().Add(2 * time.Second)
+}
+
+func TestGet(t *testing.T) {
+	c := New(int64(100), nil)
+	c.Add("key1", String("1234"), initTime())
+	if v, ok := c.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+	c.Add("key3", String("1234"), time.Now().Add(-time.Second))
+	if _, ok := c.Get("key3"); ok || c.Stats().Expirations != 1 {
+		t.Fatalf("key3 should be expired")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	callback := cache.OnEliminated(func(key string, value cache.Lengthable) {
+		keys = append(keys, key)
+	})
+	c := New(int64(10), callback)
+	c.Add("key1", String("123456"), initTime())
+	c.Add("k2", String("k2"), initTime())
+	c.Add("k3", String("k3"), initTime())
+	c.Add("k4", String("k4"), initTime())
+
+	expect := []string{"key1", "k2"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+}
+
+// S中被访问过的条目在S淘汰时移入M
+func TestPromoteToMain(t *testing.T) {
+	c := New(int64(100), nil)
+	for _, key := range "abcdefghij" {
+		c.Add(string(key), String("123456789"), time.Time{})
+	}
+	c.Get("a")
+	c.Add("k", String("123456789"), time.Time{})
+	if kv := c.hashmap["a"].Value.(*entry); kv.queue != c.main || kv.freq.Load() != 0 {
+		t.Fatalf("a should be moved to main with its frequency reset")
+	}
+	if _, ok := c.ghosts["b"]; c.Contains("b") || !ok {
+		t.Fatalf("b should be evicted and remembered in the ghost queue")
+	}
+}
+
+// G中记录过的key再次写入时直接进入M
+func TestGhostHit(t *testing.T) {
+	c := New(int64(100), nil)
+	for _, key := range "abcdefghijk" {
+		c.Add(string(key), String("123456789"), time.Time{})
+	}
+	if _, ok := c.ghosts["a"]; !ok {
+		t.Fatalf("a should be remembered in the ghost queue")
+	}
+	c.Add("a", String("123456789"), time.Time{})
+	if kv := c.hashmap["a"].Value.(*entry); kv.queue != c.main {
+		t.Fatalf("a should be inserted into main")
+	}
+	if _, ok := c.ghosts["a"]; ok {
+		t.Fatalf("a should be removed from the ghost queue")
+	}
+	if stats := c.Stats(); stats.Bytes > 100 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// 只访问一次的扫描不会挤走被反复访问的热点
+func TestScanResistance(t *testing.T) {
+	c := New(int64(200), nil)
+	for i := 0; i < 5; i++ {
+		key := "hot" + strconv.Itoa(i)
+		c.Add(key, String("0123456"), time.Time{})
+		c.Get(key)
+	}
+	for i := 0; i < 1000; i++ {
+		key := "scan" + strconv.Itoa(i)
+		if _, ok := c.Get(key); !ok {
+			c.Add(key, String("0123456"), time.Time{})
+		}
+		if i%10 == 0 {
+			c.Get("hot" + strconv.Itoa(i/10%5))
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if !c.Contains("hot" + strconv.Itoa(i)) {
+			t.Fatalf("hot%d should survive the scan", i)
+		}
+	}
+	if c.ghost.Len() > max(1, c.main.list.Len()) {
+		t.Fatalf("ghost queue %d should not exceed main %d", c.ghost.Len(), c.main.list.Len())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	c := New(int64(100), nil)
+	c.Add("myKey", String("1234"), initTime())
+	c.Add("other", String("1234"), initTime())
+	if !c.Remove("myKey") || c.Contains("myKey") || c.Len() != 1 {
+		t.Fatal("Remove myKey failed")
+	}
+	if !c.Remove("other") || c.small.list.Len() != 0 || c.small.bytes != 0 {
+		t.Fatal("Remove other failed")
+	}
+	if stats := c.Stats(); stats.Bytes != 0 || stats.Entries != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// 并发读写后占用的容量仍不超过上限 配合-race检查数据竞争
+func TestConcurrentAccess(t *testing.T) {
+	const capacity = 1000
+	c := New(int64(capacity), nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				key := "k" + strconv.Itoa(r.Intn(200))
+				switch r.Intn(10) {
+				case 0:
+					c.Remove(key)
+				case 1:
+					c.Add(key, String("0123456789"), time.Now().Add(time.Duration(r.Intn(3)-1)*time.Millisecond))
+				default:
+					if _, ok := c.Get(key); !ok {
+						c.Add(key, String("0123456789"), time.Time{})
+					}
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	if stats := c.Stats(); stats.Bytes > capacity || int(stats.Entries) != c.Len() {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -7,3 +7,23 @@ type Lengthable interface {
 
 // OnEliminated 当key-value被淘汰时 执行的处理函数
 type OnEliminated func(key string, value Lengthable)
+
+// Stats 缓存算法自身的统计信息
+type Stats struct {
+	Evictions   int64 // 因容量不足被淘汰的条目数
+	Expirations int64 // 因过期被删除的条目数
+	Bytes       int64 // 当前占用的内存大小(Byte)
+	Entries     int64 // 当前缓存的条目数
+}
+
+// StatsReporter 接口指明缓存算法可以报告自身的淘汰次数与占用情况
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// Sweeper 接口指明缓存算法可以主动清理已过期的条目
+// 否则过期条目只有在被访问或恰好被淘汰时才会删除 在此之前一直占用容量
+type Sweeper interface {
+	// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+	SweepExpired(n int) (checked, expired int)
+}
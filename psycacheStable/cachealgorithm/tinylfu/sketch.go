@@ -0,0 +1,88 @@
+package tinylfu
+
+import (
+	"hash/fnv"
+)
+
+// sketchDepth count-min sketch的行数 每个key在每行各占一个计数器
+const sketchDepth = 4
+
+// maxCount 计数器的上限 与4位计数器相同 足以区分冷热
+const maxCount = 15
+
+// sketch 是近似记录key访问频率的count-min sketch
+// 频率估计只会偏大不会偏小 每累计resetAt次访问将所有计数器减半 使旧的热度逐渐衰减
+type sketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// newSketch 创建每行至少width个计数器的sketch
+func newSketch(width int) *sketch {
+	n := 1
+	for n < width {
+		n <<= 1
+	}
+	s := &sketch{mask: uint64(n - 1), resetAt: 10 * n}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, n)
+	}
+	return s
+}
+
+// increment 将key的频率加一
+func (s *sketch) increment(key string) {
+	h1, h2 := hash(key)
+	added := false
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < maxCount {
+			s.rows[i][idx]++
+			added = true
+		}
+	}
+	if added {
+		s.additions++
+		if s.additions >= s.resetAt {
+			s.reset()
+		}
+	}
+}
+
+// estimate 返回key频率的估计值 即各行计数器中的最小值
+func (s *sketch) estimate(key string) uint8 {
+	h1, h2 := hash(key)
+	min := uint8(maxCount)
+	for i := range s.rows {
+		if c := s.rows[i][(h1+uint64(i)*h2)&s.mask]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// reset 将所有计数器减半
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// hash 由FNV-1a派生出两个哈希值 h2为奇数 使各行的位置互不相同
+func hash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	// splitmix64的终结函数 改善相近key的雪崩效果
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x, (x>>32 | x<<32) | 1
+}
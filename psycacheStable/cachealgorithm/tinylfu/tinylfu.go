@@ -0,0 +1,281 @@
+package tinylfu
+
+// tinylfu 实现W-TinyLFU缓存 参考Caffeine的设计
+// 新写入的条目先进入占总容量1%的窗口LRU 窗口溢出的条目成为候选者
+// 主缓存是分段LRU(probation+protected) 候选者只有在频率高于主缓存的淘汰者时才能进入 否则直接被淘汰
+// 频率由count-min sketch近似记录并定期衰减 因此一次性的扫描流量无法挤走真正的热点
+// 窗口让突发的新热点有机会积累频率 分段LRU让被再次访问的条目免于被新来者挤走
+
+import (
+	"container/list"
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"time"
+)
+
+const (
+	windowPercent    = 1  // 窗口占总容量的百分比
+	protectedPercent = 80 // protected段占主缓存的百分比
+
+	avgEntryBytes  = 64      // 估计sketch规模时假设的平均条目大小
+	minSketchWidth = 1 << 10 // sketch每行计数器个数的下限
+	maxSketchWidth = 1 << 20 // sketch每行计数器个数的上限
+)
+
+// entry 定义双向链表节点所存储的对象
+type entry struct {
+	key            string
+	value          cache.Lengthable
+	expirationTime time.Time
+	seg            *segment // 所在的段
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+// segment 是一段LRU链表 链头表示最近使用
+type segment struct {
+	list  *list.List
+	bytes int64 // 该段占用的内存大小(Byte)
+}
+
+func newSegment() *segment {
+	return &segment{list: list.New()}
+}
+
+// TinyLFUCache 是W-TinyLFU算法实现的缓存
+type TinyLFUCache struct {
+	capacity     int64 // Cache 最大容量(Byte)
+	windowCap    int64 // 窗口的最大容量(Byte)
+	protectedCap int64 // protected段的最大容量(Byte)
+
+	window    *segment
+	probation *segment // 主缓存中只被访问过一次的条目 淘汰者从这里选出
+	protected *segment // 主缓存中被再次访问过的条目
+	hashmap   map[string]*list.Element
+	sketch    *sketch
+
+	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
+}
+
+// New 创建指定最大容量的W-TinyLFU缓存
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func New(maxBytes int64, callback cache.OnEliminated) *TinyLFUCache {
+	width := maxBytes / avgEntryBytes
+	if width < minSketchWidth {
+		width = minSketchWidth
+	}
+	if width > maxSketchWidth {
+		width = maxSketchWidth
+	}
+	windowCap := maxBytes * windowPercent / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	return &TinyLFUCache{
+		capacity:     maxBytes,
+		windowCap:    windowCap,
+		protectedCap: (maxBytes - windowCap) * protectedPercent / 100,
+		window:       newSegment(),
+		probation:    newSegment(),
+		protected:    newSegment(),
+		hashmap:      make(map[string]*list.Element),
+		sketch:       newSketch(int(width)),
+		callback:     callback,
+	}
+}
+
+// Get 从缓存获取对应key的value 无论是否命中都会记录一次访问
+// ok 指明查询结果 false代表查无此key
+func (c *TinyLFUCache) Get(key string) (value cache.Lengthable, ok bool) {
+	c.sketch.increment(key)
+	elem, ok := c.hashmap[key]
+	if !ok {
+		return nil, false
+	}
+	kv := elem.Value.(*entry)
+	if checkExpirationTime(kv.expirationTime) {
+		c.expireElement(elem)
+		return nil, false
+	}
+	switch kv.seg {
+	case c.probation: // 再次被访问 晋升到protected段
+		c.move(elem, c.protected)
+		c.demote()
+	default:
+		kv.seg.list.MoveToFront(elem)
+	}
+	return kv.value, true
+}
+
+// Add 向缓存中添加指定key的value
+// 新的key先进入窗口 窗口溢出的条目经频率比较后才能进入主缓存
+func (c *TinyLFUCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
+	kvSize := int64(len(key)) + int64(value.Len())
+	if c.capacity != 0 && kvSize > c.capacity { //新加的这个比总容量都要大了
+		return
+	}
+	if elem, ok := c.hashmap[key]; ok {
+		// 更新缓存key值
+		kv := elem.Value.(*entry)
+		kv.seg.bytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+		kv.expirationTime = expirationTime
+		kv.seg.list.MoveToFront(elem)
+		if kv.seg == c.protected {
+			c.demote()
+		}
+	} else {
+		kv := &entry{key: key, value: value, expirationTime: expirationTime, seg: c.window}
+		c.hashmap[key] = c.window.list.PushFront(kv)
+		c.window.bytes += kvSize
+	}
+	c.evict()
+}
+
+// Remove 从缓存中移除提供的键
+func (c *TinyLFUCache) Remove(key string) (ok bool) {
+	if e, exist := c.hashmap[key]; exist {
+		c.removeElement(e)
+		return exist
+	}
+	return false
+}
+
+// Contains 检查某个键是否在缓存中，但不更新缓存的状态
+func (c *TinyLFUCache) Contains(key string) (ok bool) {
+	e, ok := c.hashmap[key]
+	if ok {
+		// 判断此值是否已经超时,如果超时则进行删除
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			return !ok
+		}
+	}
+	return ok
+}
+
+// Len 获取缓存的长度
+func (c *TinyLFUCache) Len() int {
+	return len(c.hashmap)
+}
+
+// evict 将窗口中溢出的条目交给准入过滤器
+// 更新已有的key可能使主缓存变大 此时直接淘汰主缓存尾部的条目
+func (c *TinyLFUCache) evict() {
+	if c.capacity == 0 {
+		return
+	}
+	for c.window.bytes > c.windowCap && c.window.list.Len() > 0 {
+		c.admit(c.window.list.Back())
+	}
+	for c.probation.bytes+c.protected.bytes > c.capacity-c.windowCap {
+		victim := c.probation.list.Back()
+		if victim == nil {
+			victim = c.protected.list.Back()
+		}
+		c.evictElement(victim)
+	}
+}
+
+// admit 窗口淘汰的候选者尝试进入probation段
+// 主缓存放不下时 候选者与probation段(为空时protected段)尾部的淘汰者比较频率 频率更高才能挤走淘汰者
+func (c *TinyLFUCache) admit(candidate *list.Element) {
+	kv := candidate.Value.(*entry)
+	size := kv.size()
+	mainCap := c.capacity - c.windowCap
+	freq := c.sketch.estimate(kv.key)
+	for c.probation.bytes+c.protected.bytes+size > mainCap {
+		victim := c.probation.list.Back()
+		if victim == nil {
+			victim = c.protected.list.Back()
+		}
+		if victim == nil || freq <= c.sketch.estimate(victim.Value.(*entry).key) {
+			c.evictElement(candidate)
+			return
+		}
+		c.evictElement(victim)
+	}
+	c.move(candidate, c.probation)
+}
+
+// demote protected段溢出时 将其尾部的条目降级到probation段
+func (c *TinyLFUCache) demote() {
+	for c.protected.bytes > c.protectedCap {
+		elem := c.protected.list.Back()
+		if elem == nil {
+			return
+		}
+		c.move(elem, c.probation)
+	}
+}
+
+// move 将元素移动到目标段的链头
+func (c *TinyLFUCache) move(e *list.Element, to *segment) {
+	kv := e.Value.(*entry)
+	kv.seg.list.Remove(e)
+	kv.seg.bytes -= kv.size()
+	kv.seg = to
+	c.hashmap[kv.key] = to.list.PushFront(kv)
+	to.bytes += kv.size()
+}
+
+// removeElement 删除一枚指定的元素
+func (c *TinyLFUCache) removeElement(e *list.Element) {
+	kv := e.Value.(*entry)
+	kv.seg.list.Remove(e)
+	kv.seg.bytes -= kv.size()
+	delete(c.hashmap, kv.key)
+	if c.callback != nil {
+		c.callback(kv.key, kv.value)
+	}
+}
+
+// evictElement 因容量不足淘汰一枚元素
+func (c *TinyLFUCache) evictElement(e *list.Element) {
+	c.evictions++
+	c.removeElement(e)
+}
+
+// expireElement 删除一枚已过期的元素
+func (c *TinyLFUCache) expireElement(e *list.Element) {
+	c.expirations++
+	c.removeElement(e)
+}
+
+// Stats 返回缓存的淘汰次数与占用情况
+func (c *TinyLFUCache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.window.bytes + c.probation.bytes + c.protected.bytes,
+		Entries:     int64(len(c.hashmap)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *TinyLFUCache) SweepExpired(n int) (checked, expired int) {
+	for _, e := range c.hashmap {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
+		return true
+	}
+	return false
+}
@@ -1,74 +1,273 @@
 package twoQ
 
+// twoQ 实现完整版的2Q缓存 参考Johnson与Shasha的论文
+// 第一次写入的条目进入FIFO队列A1in 在A1in中被再次访问不会改变其位置 从而过滤掉短时间内的相关访问
+// 从A1in淘汰的条目只留下key进入幽灵队列A1out 之后再次写入时说明它确实被反复使用 直接进入LRU链表Am
+// 从Am淘汰的条目不留下幽灵条目
+// A1in与Am合计不超过总容量 A1out不保存value 只记录key
+
 import (
-	cahce "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
-	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/fifo"
-	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lru"
+	"container/list"
+	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"time"
+)
+
+const (
+	defaultKin  = 0.25 // A1in默认占总容量的比例
+	defaultKout = 0.5  // A1out默认记录的key数与常驻条目数之比
 )
 
-// Cache 是LRU算法实现的缓存
-// 使用lru+FIFO实现LRU
+// entry 定义双向链表节点所存储的对象 幽灵条目的value为nil
+type entry struct {
+	key            string
+	value          cache.Lengthable
+	expirationTime time.Time
+	queue          *queue // 所在的队列
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+// queue 是一段双向链表 链头表示最近加入
+type queue struct {
+	list  *list.List
+	bytes int64 // 该队列中常驻条目占用的内存大小(Byte)
+}
+
+func newQueue() *queue {
+	return &queue{list: list.New()}
+}
+
+// TwoQCache 是2Q算法实现的缓存
 type TwoQCache struct {
-	capacity int64 // Cache 最大容量(Byte)
-	lru      *lru.LRUCache
-	FIFO     *fifo.FIFOCache
+	capacity int64   // Cache 最大容量(Byte)
+	kin      float64 // A1in占总容量的比例
+	kout     float64 // A1out记录的key数与常驻条目数之比
+
+	a1in    *queue // 第一次写入的条目 FIFO
+	a1out   *queue // 从A1in淘汰的key FIFO
+	am      *queue // 被再次使用的条目 LRU
+	hashmap map[string]*list.Element
+	ghosts  map[string]*list.Element
+
+	callback cache.OnEliminated
+
+	evictions   int64 // 因容量不足被淘汰的条目数
+	expirations int64 // 因过期被删除的条目数
 }
 
-func New(maxBytes int64, callback cahce.OnEliminated) *TwoQCache {
-	lru := lru.New(maxBytes, callback)
-	FIFO := fifo.New(maxBytes, callback)
-	return &TwoQCache{
+// Option 配置 TwoQCache
+type Option func(*TwoQCache)
+
+// WithKin 设置A1in占总容量的比例 取值在(0,1)之间 默认0.25
+func WithKin(ratio float64) Option {
+	return func(c *TwoQCache) {
+		if ratio > 0 && ratio < 1 {
+			c.kin = ratio
+		}
+	}
+}
+
+// WithKout 设置A1out最多记录的key数与常驻条目数之比 必须大于0 默认0.5
+func WithKout(ratio float64) Option {
+	return func(c *TwoQCache) {
+		if ratio > 0 {
+			c.kout = ratio
+		}
+	}
+}
+
+// New 创建指定最大容量的2Q缓存
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func New(maxBytes int64, callback cache.OnEliminated, opts ...Option) *TwoQCache {
+	c := &TwoQCache{
 		capacity: maxBytes,
-		lru:      lru,
-		FIFO:     FIFO,
+		kin:      defaultKin,
+		kout:     defaultKout,
+		a1in:     newQueue(),
+		a1out:    newQueue(),
+		am:       newQueue(),
+		hashmap:  make(map[string]*list.Element),
+		ghosts:   make(map[string]*list.Element),
+		callback: callback,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Get 在2Q缓存中获取对应key的value
-func (c *TwoQCache) Get(key string) (value cahce.Lengthable, ok bool) {
-	//能在lru中找到
-	if value, ok = c.lru.Get(key); ok {
-		return value, ok
+// 命中Am时移动到链头 命中A1in时保持原位
+func (c *TwoQCache) Get(key string) (value cache.Lengthable, ok bool) {
+	elem, ok := c.hashmap[key]
+	if !ok {
+		return nil, false
+	}
+	kv := elem.Value.(*entry)
+	if checkExpirationTime(kv.expirationTime) {
+		c.expireElement(elem)
+		return nil, false
 	}
-	//在lru中找不到，但能在FIFO中找到
-	if value, expirationTime, ok := c.FIFO.Peek(key); ok {
-		c.lru.Add(key, value, expirationTime)
-		c.FIFO.Remove(key)
-		return value, ok
+	if kv.queue == c.am {
+		c.am.list.MoveToFront(elem)
 	}
-	return nil, false
+	return kv.value, true
 }
 
 // Add 向缓存中添加指定key的value
-func (c *TwoQCache) Add(key string, value cahce.Lengthable, expirationTime int64) {
-	if c.lru.Contains(key) {
-		c.lru.Add(key, value, expirationTime)
-	} else if ok := c.FIFO.Contains(key); ok {
-		c.lru.Add(key, value, expirationTime)
-		c.FIFO.Remove(key)
-	} else {
-		c.FIFO.Add(key, value, expirationTime)
+// 新的key进入A1in 记录在A1out中的key直接进入Am
+func (c *TwoQCache) Add(key string, value cache.Lengthable, expirationTime time.Time) {
+	kvSize := int64(len(key)) + int64(value.Len())
+	if c.capacity != 0 && kvSize > c.capacity { //新加的这个比总容量都要大了
+		return
+	}
+	if elem, ok := c.hashmap[key]; ok {
+		// 更新缓存key值
+		kv := elem.Value.(*entry)
+		kv.queue.bytes += kvSize - kv.size()
+		kv.value = value
+		kv.expirationTime = expirationTime
+		if kv.queue == c.am {
+			c.am.list.MoveToFront(elem)
+		}
+		c.reclaim(0)
+		c.trimGhosts()
+		return
 	}
-	return
+	kv := &entry{key: key, value: value, expirationTime: expirationTime}
+	to := c.a1in
+	if ghost, ok := c.ghosts[key]; ok {
+		c.removeGhost(ghost)
+		to = c.am
+	}
+	c.reclaim(kvSize)
+	kv.queue = to
+	c.hashmap[key] = to.list.PushFront(kv)
+	to.bytes += kvSize
+	c.trimGhosts()
 }
 
-// Remove 从缓存中移除提供的键
+// Remove 从缓存中移除提供的键 该key在A1out中的记录一并删除
 func (c *TwoQCache) Remove(key string) (ok bool) {
-	if c.lru.Contains(key) {
-		ok := c.lru.Remove(key)
-		return ok
+	if e, exist := c.ghosts[key]; exist {
+		c.removeGhost(e)
 	}
-
-	if c.FIFO.Contains(key) {
-		ok := c.FIFO.Remove(key)
-		return ok
+	if e, exist := c.hashmap[key]; exist {
+		c.removeElement(e)
+		return exist
 	}
 	return false
 }
 
 // Contains 检查某个键是否在缓存中，但不更新缓存的状态
 func (c *TwoQCache) Contains(key string) (ok bool) {
-	if c.lru.Contains(key) || c.FIFO.Contains(key) {
+	e, ok := c.hashmap[key]
+	if ok {
+		// 判断此值是否已经超时,如果超时则进行删除
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			return !ok
+		}
+	}
+	return ok
+}
+
+// Len 获取缓存的长度 不包括A1out中的key
+func (c *TwoQCache) Len() int {
+	return len(c.hashmap)
+}
+
+// reclaim 淘汰常驻条目 直到能再放入size大小的条目
+// A1in超过其份额时淘汰A1in最早加入的条目并将key记入A1out 否则淘汰Am中最久未使用的条目
+func (c *TwoQCache) reclaim(size int64) {
+	if c.capacity == 0 {
+		return
+	}
+	kinBytes := int64(float64(c.capacity) * c.kin)
+	for c.a1in.bytes+c.am.bytes+size > c.capacity {
+		if back := c.a1in.list.Back(); back != nil && (c.a1in.bytes > kinBytes || c.am.list.Len() == 0) {
+			kv := back.Value.(*entry)
+			c.evictElement(back)
+			kv.value = nil
+			kv.queue = c.a1out
+			c.ghosts[kv.key] = c.a1out.list.PushFront(kv)
+			continue
+		}
+		c.evictElement(c.am.list.Back())
+	}
+}
+
+// trimGhosts 丢弃A1out中最早加入的key 使其记录的key数不超过常驻条目数的kout倍
+func (c *TwoQCache) trimGhosts() {
+	limit := int(float64(len(c.hashmap)) * c.kout)
+	if limit < 1 {
+		limit = 1
+	}
+	for c.a1out.list.Len() > limit {
+		c.removeGhost(c.a1out.list.Back())
+	}
+}
+
+// removeGhost 删除A1out中的一个key
+func (c *TwoQCache) removeGhost(e *list.Element) {
+	c.a1out.list.Remove(e)
+	delete(c.ghosts, e.Value.(*entry).key)
+}
+
+// removeElement 删除一枚指定的常驻元素
+func (c *TwoQCache) removeElement(e *list.Element) {
+	kv := e.Value.(*entry)
+	kv.queue.list.Remove(e)
+	kv.queue.bytes -= kv.size()
+	delete(c.hashmap, kv.key)
+	if c.callback != nil {
+		c.callback(kv.key, kv.value)
+	}
+}
+
+// evictElement 因容量不足淘汰一枚元素
+func (c *TwoQCache) evictElement(e *list.Element) {
+	c.evictions++
+	c.removeElement(e)
+}
+
+// expireElement 删除一枚已过期的元素 过期的条目不记入A1out
+func (c *TwoQCache) expireElement(e *list.Element) {
+	c.expirations++
+	c.removeElement(e)
+}
+
+// Stats 返回缓存的淘汰次数与占用情况 不包括A1out中的key
+func (c *TwoQCache) Stats() cache.Stats {
+	return cache.Stats{
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+		Bytes:       c.a1in.bytes + c.am.bytes,
+		Entries:     int64(len(c.hashmap)),
+	}
+}
+
+// SweepExpired 随机检查至多n个条目 删除其中已过期的 返回检查与删除的条目数
+// map的遍历起点是随机的 因此每次检查的是不同的一批条目
+func (c *TwoQCache) SweepExpired(n int) (checked, expired int) {
+	for _, e := range c.hashmap {
+		if checked >= n {
+			break
+		}
+		checked++
+		if checkExpirationTime(e.Value.(*entry).expirationTime) {
+			c.expireElement(e)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+// checkExpirationTime 判断缓存是否已经过期 零值代表永不过期
+func checkExpirationTime(expirationTime time.Time) (ok bool) {
+	if !expirationTime.IsZero() && !time.Now().Before(expirationTime) {
 		return true
 	}
 	return false
@@ -2,7 +2,9 @@ package twoQ
 
 import (
 	cache "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"math/rand"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -14,8 +16,8 @@ func (d String) Len() int {
 }
 
 // 生成当前时间 + 2秒
-func initTime() int64 {
-	return time.Now().UnixNano()/1e6 + 2000
+func initTime() time.Time {
+	return time.Now().Add(2 * time.Second)
 }
 
 func TestGet(t *testing.T) {
@@ -56,15 +58,58 @@ func TestOnEvicted(t *testing.T) {
 
 func TestAdd(t *testing.T) {
 	initTime := initTime()
-	twoQ := New(int64(7), nil)
+	twoQ := New(int64(20), nil)
 	twoQ.Add("key1", String("1"), initTime)
 	twoQ.Add("key1", String("111"), initTime)
+	twoQ.Get("key1")
 	twoQ.Add("key2", String("2"), initTime)
-	if twoQ.lru.Len() != 1 || twoQ.FIFO.Len() != 1 {
+	// 仍在A1in中的key被再次访问或更新 不会进入Am
+	if twoQ.a1in.list.Len() != 2 || twoQ.am.list.Len() != 0 || twoQ.a1in.bytes != 12 {
 		t.Fatal("func Add has something wrong")
 	}
 }
 
+// 从A1in淘汰的key记入A1out 再次写入时直接进入Am
+func TestPromoteFromGhost(t *testing.T) {
+	twoQ := New(int64(20), nil)
+	twoQ.Add("k1", String("12345678"), time.Time{})
+	twoQ.Add("k2", String("12345678"), time.Time{})
+	twoQ.Add("k3", String("12345678"), time.Time{}) // k1被淘汰 只留下key
+	if twoQ.Contains("k1") || twoQ.a1out.list.Len() != 1 {
+		t.Fatalf("k1 should be remembered in A1out")
+	}
+	twoQ.Add("k1", String("12345678"), time.Time{})
+	if e, ok := twoQ.hashmap["k1"]; !ok || e.Value.(*entry).queue != twoQ.am {
+		t.Fatalf("k1 should be promoted to Am")
+	}
+	if _, ok := twoQ.ghosts["k1"]; ok {
+		t.Fatalf("k1 should be removed from A1out")
+	}
+}
+
+// A1in与Am合计不超过总容量 A1out的记录数不超过常驻条目数的kout倍
+func TestCapacity(t *testing.T) {
+	const capacity = 500
+	twoQ := New(int64(capacity), nil, WithKin(0.3), WithKout(2))
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := "k" + strconv.Itoa(r.Intn(200))
+		if _, ok := twoQ.Get(key); !ok {
+			twoQ.Add(key, String(make([]byte, r.Intn(30))), time.Time{})
+		}
+		stats := twoQ.Stats()
+		if stats.Bytes > capacity || stats.Bytes != twoQ.a1in.bytes+twoQ.am.bytes {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+		if limit := max(1, int(float64(stats.Entries)*2)); twoQ.a1out.list.Len() > limit {
+			t.Fatalf("A1out holds %d keys, limit %d", twoQ.a1out.list.Len(), limit)
+		}
+	}
+	if twoQ.am.list.Len() == 0 || twoQ.a1out.list.Len() == 0 {
+		t.Fatalf("Am and A1out should both be used")
+	}
+}
+
 func TestRemove(t *testing.T) {
 	initTime := initTime()
 	twoQ := New(int64(6), nil)
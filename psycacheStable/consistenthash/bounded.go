@@ -0,0 +1,127 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	DefaultPartitionCount  = 271
+	DefaultBoundedReplicas = 20
+	DefaultBoundedLoad     = 1.25
+)
+
+// Bounded 实现有界负载的一致性哈希(Consistent Hashing with Bounded Loads)
+// key先被哈希到固定个数的分区 分区再按哈希环分配给peer
+// 每个peer最多负责 ceil(平均分区数*load) 个分区 超出后顺延给环上的下一个peer
+// 分配只取决于peer集合 因此各节点的结果一致 避免了哈希环上的数据倾斜
+type Bounded struct {
+	hash           Hash64Func
+	partitionCount int
+	replicas       int
+	load           float64
+	peers          map[string]struct{}
+	names          []string          // 排好序的peer
+	ring           []uint64          // 虚拟节点哈希环
+	hashmap        map[uint64]string // hashValue -> peerName
+	partitions     []string          // 分区 -> peerName
+}
+
+// NewBounded 创建一个有界负载的一致性哈希
+// partitionCount为分区个数 replicas为每个peer的虚拟节点个数 load为负载上限系数(须大于1)
+// 参数为0时使用默认值 fn为空时使用FNV-1a
+func NewBounded(partitionCount int, replicas int, load float64, fn Hash64Func) *Bounded {
+	if partitionCount <= 0 {
+		partitionCount = DefaultPartitionCount
+	}
+	if replicas <= 0 {
+		replicas = DefaultBoundedReplicas
+	}
+	if load <= 1 {
+		load = DefaultBoundedLoad
+	}
+	if fn == nil {
+		fn = fnv64a
+	}
+	return &Bounded{
+		hash:           fn,
+		partitionCount: partitionCount,
+		replicas:       replicas,
+		load:           load,
+		peers:          make(map[string]struct{}),
+	}
+}
+
+// Register 将peer加入候选集合并重新分配分区
+func (b *Bounded) Register(peersName ...string) {
+	for _, peerName := range peersName {
+		b.peers[peerName] = struct{}{}
+	}
+	b.distribute()
+}
+
+// Remove 将peer移出候选集合并重新分配分区
+func (b *Bounded) Remove(peerName string) {
+	if _, ok := b.peers[peerName]; !ok {
+		return
+	}
+	delete(b.peers, peerName)
+	b.distribute()
+}
+
+// GetPeer 计算key应缓存到的peer
+func (b *Bounded) GetPeer(key string) string {
+	if len(b.partitions) == 0 {
+		return ""
+	}
+	return b.partitions[b.hash([]byte(key))%uint64(b.partitionCount)]
+}
+
+// Peers 返回所有peer
+func (b *Bounded) Peers() []string {
+	return append([]string(nil), b.names...)
+}
+
+// distribute 重建哈希环 并在负载上限内为每个分区分配peer
+func (b *Bounded) distribute() {
+	b.names = sortedPeers(b.peers)
+	b.ring = b.ring[:0]
+	b.hashmap = make(map[uint64]string)
+	for _, peerName := range b.names {
+		for i := 0; i < b.replicas; i++ {
+			hashValue := b.hash([]byte(strconv.Itoa(i) + peerName))
+			if _, ok := b.hashmap[hashValue]; ok {
+				continue
+			}
+			b.ring = append(b.ring, hashValue)
+			b.hashmap[hashValue] = peerName
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i] < b.ring[j] })
+	if len(b.ring) == 0 {
+		b.partitions = nil
+		return
+	}
+
+	maxLoad := int(math.Ceil(float64(b.partitionCount) / float64(len(b.names)) * b.load))
+	loads := make(map[string]int, len(b.names))
+	partitions := make([]string, b.partitionCount)
+	for partID := range partitions {
+		hashValue := b.hash([]byte(strconv.Itoa(partID)))
+		idx := sort.Search(len(b.ring), func(i int) bool {
+			return b.ring[i] >= hashValue
+		})
+		// 负载已满的peer跳过 顺延给环上的下一个peer
+		for {
+			peerName := b.hashmap[b.ring[idx%len(b.ring)]]
+			if loads[peerName] < maxLoad {
+				partitions[partID] = peerName
+				loads[peerName]++
+				break
+			}
+			idx++
+		}
+	}
+	b.partitions = partitions
+}
@@ -15,40 +15,229 @@ type HashFunc func(data []byte) uint32
 // Consistency 维护peer与其hash值的关联
 type Consistency struct {
 	hash     HashFunc       // 哈希函数依赖
-	replicas int            // 虚拟节点个数(防止数据倾斜)
+	replicas int            // 每单位权重的虚拟节点个数(防止数据倾斜)
 	ring     []int          // uint32哈希环
 	hashmap  map[int]string // hashValue -> peerName
+	weights  map[string]int // peerName -> 权重
+	claims   map[int]int    // hashValue -> 落在该位置的虚拟节点个数 只记录发生哈希冲突的位置
 }
 
-// Register 将各个peer注册到哈希环上
+// Range 描述哈希环上一段哈希区间(Start, End]的归属变化
+// Start >= End 时代表该区间跨越了哈希环的零点
+type Range struct {
+	Start uint32
+	End   uint32
+	From  string // 变化前负责该区间的peer 为空代表原本没有peer
+	To    string // 变化后负责该区间的peer 为空代表之后没有peer
+}
+
+// Register 将各个peer注册到哈希环上 每个peer的权重为1
 func (c *Consistency) Register(peersName ...string) {
 	for _, peerName := range peersName {
-		for i := 0; i < c.replicas; i++ {
-			hashValue := int(c.hash([]byte(strconv.Itoa(i) + peerName)))
-			c.ring = append(c.ring, hashValue)
-			c.hashmap[hashValue] = peerName
+		c.add(peerName, 1)
+	}
+	sort.Ints(c.ring)
+}
+
+// RegisterWeighted 以指定权重将peer注册到哈希环上
+// 虚拟节点个数为 replicas*weight 内存更大的机器可以给更高的权重
+// 若peer已经注册 则更新其权重
+func (c *Consistency) RegisterWeighted(peerName string, weight int) {
+	if weight <= 0 {
+		c.Remove(peerName)
+		return
+	}
+	if old, ok := c.weights[peerName]; ok {
+		if old == weight {
+			return
 		}
+		c.Remove(peerName)
 	}
+	c.add(peerName, weight)
 	sort.Ints(c.ring)
 }
 
+// add 将peer的虚拟节点加入哈希环 调用方负责对哈希环重新排序
+// 与已有虚拟节点哈希冲突时 名字较小的peer占据该位置 这样哈希环与注册顺序无关
+func (c *Consistency) add(peerName string, weight int) {
+	if _, ok := c.weights[peerName]; ok {
+		return
+	}
+	c.weights[peerName] = weight
+	for i := 0; i < c.replicas*weight; i++ {
+		hashValue := int(c.hash([]byte(strconv.Itoa(i) + peerName)))
+		if owner, ok := c.hashmap[hashValue]; ok {
+			c.claims[hashValue] = max(c.claims[hashValue], 1) + 1
+			if peerName < owner {
+				c.hashmap[hashValue] = peerName
+			}
+			continue
+		}
+		c.ring = append(c.ring, hashValue)
+		c.hashmap[hashValue] = peerName
+	}
+}
+
+// Remove 将peer及其虚拟节点从哈希环上移除 其余peer的虚拟节点保持不变
+// 被移除的peer占据的冲突位置 交还给剩余peer中名字最小的一个
+func (c *Consistency) Remove(peerName string) {
+	weight, ok := c.weights[peerName]
+	if !ok {
+		return
+	}
+	delete(c.weights, peerName)
+	freed := make(map[int]struct{}) // 需要重新分配的冲突位置
+	for i := 0; i < c.replicas*weight; i++ {
+		hashValue := int(c.hash([]byte(strconv.Itoa(i) + peerName)))
+		if n, collided := c.claims[hashValue]; collided {
+			if n--; n > 1 {
+				c.claims[hashValue] = n
+			} else {
+				delete(c.claims, hashValue)
+			}
+			if c.hashmap[hashValue] == peerName {
+				freed[hashValue] = struct{}{}
+			}
+			continue
+		}
+		if c.hashmap[hashValue] == peerName {
+			delete(c.hashmap, hashValue)
+		}
+	}
+	if len(freed) > 0 {
+		c.reclaim(freed)
+	}
+	ring := c.ring[:0]
+	for _, hashValue := range c.ring {
+		if _, ok := c.hashmap[hashValue]; ok {
+			ring = append(ring, hashValue)
+		}
+	}
+	c.ring = ring
+}
+
+// reclaim 从剩余peer的虚拟节点中 为空出的冲突位置重新选出名字最小的peer 没有peer落在该位置时将其删除
+func (c *Consistency) reclaim(freed map[int]struct{}) {
+	for hashValue := range freed {
+		delete(c.hashmap, hashValue)
+	}
+	for peerName, weight := range c.weights {
+		for i := 0; i < c.replicas*weight; i++ {
+			hashValue := int(c.hash([]byte(strconv.Itoa(i) + peerName)))
+			if _, ok := freed[hashValue]; !ok {
+				continue
+			}
+			if owner, ok := c.hashmap[hashValue]; !ok || peerName < owner {
+				c.hashmap[hashValue] = peerName
+			}
+		}
+	}
+}
+
+// Peers 返回哈希环上的所有peer
+func (c *Consistency) Peers() []string {
+	return sortedPeers(c.weights)
+}
+
+// Weights 返回哈希环上的所有peer及其权重
+func (c *Consistency) Weights() map[string]int {
+	weights := make(map[string]int, len(c.weights))
+	for peerName, weight := range c.weights {
+		weights[peerName] = weight
+	}
+	return weights
+}
+
 // GetPeer 计算key应缓存到的peer
 func (c *Consistency) GetPeer(key string) string {
+	return c.owner(int(c.hash([]byte(key))))
+}
+
+// owner 返回负责该哈希值的peer
+func (c *Consistency) owner(hashValue int) string {
 	if len(c.ring) == 0 {
 		return ""
 	}
-	hashValue := int(c.hash([]byte(key)))
 	idx := sort.Search(len(c.ring), func(i int) bool {
 		return c.ring[i] >= hashValue
 	})
 	return c.hashmap[c.ring[idx%len(c.ring)]]
 }
 
+// Clone 复制一份哈希环 可以在副本上模拟节点变化 再通过 Diff 查看受影响的区间
+func (c *Consistency) Clone() *Consistency {
+	clone := New(c.replicas, c.hash)
+	clone.ring = append([]int(nil), c.ring...)
+	for hashValue, peerName := range c.hashmap {
+		clone.hashmap[hashValue] = peerName
+	}
+	for peerName, weight := range c.weights {
+		clone.weights[peerName] = weight
+	}
+	for hashValue, n := range c.claims {
+		clone.claims[hashValue] = n
+	}
+	return clone
+}
+
+// Diff 对比当前哈希环与next 返回归属发生变化的哈希区间
+// 两个哈希环须使用相同的哈希函数 相邻且变化相同的区间会被合并
+func (c *Consistency) Diff(next *Consistency) []Range {
+	bounds := mergeRings(c.ring, next.ring)
+	if len(bounds) == 0 {
+		return nil
+	}
+	var moves []Range
+	for i, end := range bounds {
+		start := bounds[(i+len(bounds)-1)%len(bounds)]
+		from, to := c.owner(end), next.owner(end)
+		if from == to {
+			continue
+		}
+		if n := len(moves); n > 0 && int(moves[n-1].End) == start &&
+			moves[n-1].From == from && moves[n-1].To == to {
+			moves[n-1].End = uint32(end)
+			continue
+		}
+		moves = append(moves, Range{Start: uint32(start), End: uint32(end), From: from, To: to})
+	}
+	// 首尾两段在零点处相接时合并
+	if n := len(moves); n > 1 && moves[n-1].End == moves[0].Start &&
+		moves[n-1].From == moves[0].From && moves[n-1].To == moves[0].To {
+		moves[0].Start = moves[n-1].Start
+		moves = moves[:n-1]
+	}
+	return moves
+}
+
+// mergeRings 合并两个有序哈希环 返回去重后的有序哈希值
+func mergeRings(a, b []int) []int {
+	merged := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var v int
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			v, i = a[i], i+1
+		case i == len(a) || b[j] < a[i]:
+			v, j = b[j], j+1
+		default:
+			v, i, j = a[i], i+1, j+1
+		}
+		if n := len(merged); n == 0 || merged[n-1] != v {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
+
 func New(replicas int, fn HashFunc) *Consistency {
 	c := &Consistency{
 		replicas: replicas,
 		hash:     fn,
 		hashmap:  make(map[int]string),
+		weights:  make(map[string]int),
+		claims:   make(map[int]int),
 	}
 	if c.hash == nil {
 		c.hash = crc32.ChecksumIEEE
@@ -3,7 +3,9 @@ package consistenthash
 import (
 	"hash/crc32"
 	"log"
+	"reflect"
 	"sort"
+	"strconv"
 	"testing"
 )
 
@@ -35,3 +37,104 @@ func TestConsistency_GetPeer(t *testing.T) {
 	peer := c.GetPeer(key)
 	log.Printf("Go to search -> %s\n", peer)
 }
+
+func TestConsistency_RegisterWeighted(t *testing.T) {
+	c := New(2, nil)
+	c.RegisterWeighted("peer1", 1)
+	c.RegisterWeighted("peer2", 3)
+	// Expect: replicas*weight
+	if len(c.ring) != 8 {
+		t.Errorf("Actual: %d\tExpect: %d\n", len(c.ring), 8)
+	}
+	// 更新权重
+	c.RegisterWeighted("peer2", 2)
+	if len(c.ring) != 6 || c.Weights()["peer2"] != 2 {
+		t.Errorf("Actual: %d\tExpect: %d\n", len(c.ring), 6)
+	}
+}
+
+func TestConsistency_Remove(t *testing.T) {
+	c := New(50, nil)
+	c.Register("peer1", "peer2", "peer3")
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		before[key] = c.GetPeer(key)
+	}
+	c.Remove("peer2")
+	if len(c.ring) != 100 {
+		t.Errorf("Actual: %d\tExpect: %d\n", len(c.ring), 100)
+	}
+	// 只有原本属于peer2的key会被重新分配
+	for key, peer := range before {
+		now := c.GetPeer(key)
+		if now == "peer2" || (peer != "peer2" && now != peer) {
+			t.Fatalf("key %s moved from %s to %s", key, peer, now)
+		}
+	}
+}
+
+// 哈希冲突的位置由名字较小的peer占据 移除后交还给其余peer 哈希环只取决于peer集合
+func TestConsistency_Collision(t *testing.T) {
+	// 只取首字符(虚拟节点序号) 所有peer的第i个虚拟节点都落在同一位置
+	hash := func(data []byte) uint32 {
+		return uint32(data[0])
+	}
+	ring := func(peers ...string) map[int]string {
+		c := New(3, hash)
+		c.Register(peers...)
+		return c.hashmap
+	}
+	if !reflect.DeepEqual(ring("peer1", "peer2"), ring("peer2", "peer1")) {
+		t.Fatal("ring should not depend on registration order")
+	}
+
+	c := New(3, hash)
+	c.Register("peer1", "peer2", "peer3")
+	c.Remove("peer1")
+	if expect := ring("peer2", "peer3"); !reflect.DeepEqual(c.hashmap, expect) || len(c.ring) != 3 {
+		t.Fatalf("Actual: %v\tExpect: %v", c.hashmap, expect)
+	}
+	c.Remove("peer3")
+	c.Remove("peer2")
+	if len(c.ring) != 0 || len(c.hashmap) != 0 || len(c.claims) != 0 {
+		t.Fatalf("ring should be empty, got %v %v", c.hashmap, c.claims)
+	}
+}
+
+func TestConsistency_Diff(t *testing.T) {
+	c := New(50, nil)
+	c.Register("peer1", "peer2")
+	next := c.Clone()
+	next.Register("peer3")
+
+	moves := c.Diff(next)
+	if len(moves) == 0 {
+		t.Fatal("adding a peer should move some ranges")
+	}
+	for _, r := range moves {
+		if r.To != "peer3" || r.From == "peer3" {
+			t.Fatalf("unexpected move %+v", r)
+		}
+	}
+	// Diff给出的区间应与实际发生迁移的key一致
+	inMoves := func(h uint32) bool {
+		for _, r := range moves {
+			if (r.Start < r.End && h > r.Start && h <= r.End) ||
+				(r.Start >= r.End && (h > r.Start || h <= r.End)) {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		moved := c.GetPeer(key) != next.GetPeer(key)
+		if moved != inMoves(crc32.ChecksumIEEE([]byte(key))) {
+			t.Fatalf("key %s moved=%v but Diff disagrees", key, moved)
+		}
+	}
+	if len(next.Diff(next.Clone())) != 0 {
+		t.Fatal("identical rings should have no diff")
+	}
+}
@@ -0,0 +1,63 @@
+package consistenthash
+
+// Jump 实现Lamping与Veach提出的Jump一致性哈希
+// 不需要额外内存且分布非常均匀 但peer只能以编号区分
+// 这里按名字排序为peer编号 因此只有排在最后的peer增删时迁移量最小
+// 在中间插入/移除peer会使后面所有编号整体移动 适合节点基本固定的部署
+type Jump struct {
+	hash  Hash64Func
+	peers map[string]struct{}
+	names []string // 排好序的peer 下标即桶编号
+}
+
+// NewJump 创建一个jump一致性哈希 fn为空时使用FNV-1a
+func NewJump(fn Hash64Func) *Jump {
+	if fn == nil {
+		fn = fnv64a
+	}
+	return &Jump{
+		hash:  fn,
+		peers: make(map[string]struct{}),
+	}
+}
+
+// Register 将peer加入候选集合
+func (j *Jump) Register(peersName ...string) {
+	for _, peerName := range peersName {
+		j.peers[peerName] = struct{}{}
+	}
+	j.names = sortedPeers(j.peers)
+}
+
+// Remove 将peer移出候选集合
+func (j *Jump) Remove(peerName string) {
+	if _, ok := j.peers[peerName]; !ok {
+		return
+	}
+	delete(j.peers, peerName)
+	j.names = sortedPeers(j.peers)
+}
+
+// GetPeer 计算key应缓存到的peer
+func (j *Jump) GetPeer(key string) string {
+	if len(j.names) == 0 {
+		return ""
+	}
+	return j.names[jumpHash(j.hash([]byte(key)), len(j.names))]
+}
+
+// Peers 返回所有peer
+func (j *Jump) Peers() []string {
+	return append([]string(nil), j.names...)
+}
+
+// jumpHash 将key映射到[0, buckets)中的一个桶
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,123 @@
+package consistenthash
+
+// DefaultMaglevTableSize 默认的Maglev查找表大小 须为远大于peer个数的质数
+const DefaultMaglevTableSize = 65537
+
+// Maglev 实现Google Maglev论文中的一致性哈希
+// 每个peer按自己的排列轮流填充查找表 查找时只需一次取模 分布几乎完全均匀
+// peer变化时需要重建查找表 迁移量略高于哈希环
+type Maglev struct {
+	hash      Hash64Func
+	tableSize uint64
+	peers     map[string]struct{}
+	names     []string // 排好序的peer
+	table     []int    // 查找表 槽位 -> names下标
+}
+
+// NewMaglev 创建一个Maglev哈希 tableSize为0时使用 DefaultMaglevTableSize
+// tableSize须为质数 否则与其不互质的步长无法遍历所有槽位 因此非质数会被向上取为下一个质数
+// fn为空时使用FNV-1a
+func NewMaglev(tableSize uint64, fn Hash64Func) *Maglev {
+	if tableSize == 0 {
+		tableSize = DefaultMaglevTableSize
+	}
+	tableSize = nextPrime(tableSize)
+	if fn == nil {
+		fn = fnv64a
+	}
+	return &Maglev{
+		hash:      fn,
+		tableSize: tableSize,
+		peers:     make(map[string]struct{}),
+	}
+}
+
+// Register 将peer加入候选集合并重建查找表
+func (m *Maglev) Register(peersName ...string) {
+	for _, peerName := range peersName {
+		m.peers[peerName] = struct{}{}
+	}
+	m.populate()
+}
+
+// Remove 将peer移出候选集合并重建查找表
+func (m *Maglev) Remove(peerName string) {
+	if _, ok := m.peers[peerName]; !ok {
+		return
+	}
+	delete(m.peers, peerName)
+	m.populate()
+}
+
+// GetPeer 计算key应缓存到的peer
+func (m *Maglev) GetPeer(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.names[m.table[m.hash([]byte(key))%m.tableSize]]
+}
+
+// Peers 返回所有peer
+func (m *Maglev) Peers() []string {
+	return append([]string(nil), m.names...)
+}
+
+// populate 按论文中的算法填充查找表
+func (m *Maglev) populate() {
+	m.names = sortedPeers(m.peers)
+	n := len(m.names)
+	if n == 0 {
+		m.table = nil
+		return
+	}
+	offsets := make([]uint64, n)
+	skips := make([]uint64, n)
+	for i, peerName := range m.names {
+		h := m.hash([]byte(peerName))
+		offsets[i] = h % m.tableSize
+		skips[i] = mix64(h)%(m.tableSize-1) + 1
+	}
+
+	table := make([]int, m.tableSize)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]uint64, n)
+	for filled := uint64(0); ; {
+		for i := 0; i < n; i++ {
+			slot := (offsets[i] + next[i]*skips[i]) % m.tableSize
+			for table[slot] >= 0 {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % m.tableSize
+			}
+			table[slot] = i
+			next[i]++
+			if filled++; filled == m.tableSize {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+// nextPrime 返回不小于n的最小质数 至少为2
+func nextPrime(n uint64) uint64 {
+	for n = max(n, 2); ; n++ {
+		if isPrime(n) {
+			return n
+		}
+	}
+}
+
+// isPrime 试除法判断n是否为质数 查找表大小通常在百万以内
+func isPrime(n uint64) bool {
+	if n < 2 {
+		return false
+	}
+	for d := uint64(2); d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
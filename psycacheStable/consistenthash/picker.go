@@ -0,0 +1,62 @@
+package consistenthash
+
+// picker 模块定义了key到peer的映射策略接口
+// 除哈希环外 还提供了rendezvous/jump/maglev/bounded-load几种策略 可按部署场景选择
+// 所有策略的结果只取决于peer集合本身 与注册顺序无关 这样各节点对同一个key能得出相同的peer
+// 哈希环上虚拟节点冲突的位置按peer名字决定归属 因此哈希环同样满足这一点
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// PeerPicker 定义了将key映射到peer的能力
+type PeerPicker interface {
+	// Register 将peer加入候选集合 已存在的peer会被忽略
+	Register(peersName ...string)
+	// Remove 将peer移出候选集合
+	Remove(peerName string)
+	// GetPeer 计算key应缓存到的peer 没有任何peer时返回空字符串
+	GetPeer(key string) string
+	// Peers 返回所有peer 按名字排序
+	Peers() []string
+}
+
+// Hash64Func 定义64位哈希函数输入输出
+type Hash64Func func(data []byte) uint64
+
+// fnv64a 默认的64位哈希函数 在FNV-1a之后再做一次混淆 改善相近字符串的雪崩效果
+func fnv64a(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return mix64(h.Sum64())
+}
+
+// mix64 splitmix64的终结函数 将输入的每一位均匀扩散到输出
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// sortedPeers 返回集合中按名字排好序的peer
+func sortedPeers[V any](peers map[string]V) []string {
+	names := make([]string, 0, len(peers))
+	for peerName := range peers {
+		names = append(names, peerName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 测试各策略是否实现了PeerPicker接口
+var (
+	_ PeerPicker = (*Consistency)(nil)
+	_ PeerPicker = (*Rendezvous)(nil)
+	_ PeerPicker = (*Jump)(nil)
+	_ PeerPicker = (*Maglev)(nil)
+	_ PeerPicker = (*Bounded)(nil)
+)
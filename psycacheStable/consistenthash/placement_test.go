@@ -0,0 +1,162 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// 各策略的构造函数 用于对比分布质量与迁移比例
+var pickers = []struct {
+	name string
+	new  func() PeerPicker
+	// 分布质量与迁移比例的上限 超出说明实现有误
+	maxSkew  float64 // 负载最大的peer与平均负载之比
+	maxRemap float64 // 新增一个peer时迁移的key与理想迁移量之比
+}{
+	{"ring", func() PeerPicker { return New(50, nil) }, 2, 1.6},
+	{"rendezvous", func() PeerPicker { return NewRendezvous(nil) }, 1.1, 1.2},
+	{"jump", func() PeerPicker { return NewJump(nil) }, 1.1, math.Inf(1)},
+	{"maglev", func() PeerPicker { return NewMaglev(0, nil) }, 1.1, 2},
+	{"bounded", func() PeerPicker { return NewBounded(0, 0, 0, nil) }, 1.35, 3},
+}
+
+func peerNames(n int) []string {
+	peers := make([]string, n)
+	for i := range peers {
+		peers[i] = fmt.Sprintf("10.0.0.%d:6324", i+1)
+	}
+	return peers
+}
+
+// TestPeerPicker_Distribution 对比各策略的key分布与节点变化时的迁移比例
+// jump按名字为peer编号 新peer排在中间时迁移量很大 因此不限制其迁移比例
+func TestPeerPicker_Distribution(t *testing.T) {
+	const peersNum, keysNum = 10, 100000
+	for _, p := range pickers {
+		picker := p.new()
+		picker.Register(peerNames(peersNum)...)
+
+		owners := make([]string, keysNum)
+		counts := make(map[string]int)
+		for i := range owners {
+			owners[i] = picker.GetPeer(strconv.Itoa(i))
+			counts[owners[i]]++
+		}
+		if len(counts) != peersNum {
+			t.Fatalf("[%s] only %d peers own keys", p.name, len(counts))
+		}
+		mean := float64(keysNum) / peersNum
+		var maxCount, variance float64
+		for _, count := range counts {
+			maxCount = math.Max(maxCount, float64(count))
+			variance += (float64(count) - mean) * (float64(count) - mean)
+		}
+		skew, stddev := maxCount/mean, math.Sqrt(variance/peersNum)/mean
+
+		picker.Register("10.0.0.100:6324")
+		moved := 0
+		for i, owner := range owners {
+			if picker.GetPeer(strconv.Itoa(i)) != owner {
+				moved++
+			}
+		}
+		remap := float64(moved) / keysNum / (1.0 / (peersNum + 1))
+
+		t.Logf("[%-10s] max/mean=%.3f stddev/mean=%.3f remap(actual/ideal)=%.3f", p.name, skew, stddev, remap)
+		if skew > p.maxSkew {
+			t.Errorf("[%s] max/mean %.3f exceeds %.3f", p.name, skew, p.maxSkew)
+		}
+		if remap > p.maxRemap {
+			t.Errorf("[%s] remap ratio %.3f exceeds %.3f", p.name, remap, p.maxRemap)
+		}
+	}
+}
+
+// TestPeerPicker_Remove 移除peer后 该peer不再负责任何key 其余peer的key尽量保持不动
+func TestPeerPicker_Remove(t *testing.T) {
+	for _, p := range pickers {
+		picker := p.new()
+		peers := peerNames(5)
+		picker.Register(peers...)
+		picker.Remove(peers[4])
+		if len(picker.Peers()) != 4 {
+			t.Fatalf("[%s] Actual: %d\tExpect: %d peers", p.name, len(picker.Peers()), 4)
+		}
+		for i := 0; i < 1000; i++ {
+			if picker.GetPeer(strconv.Itoa(i)) == peers[4] {
+				t.Fatalf("[%s] removed peer should not own any key", p.name)
+			}
+		}
+		for _, peerName := range peers[:4] {
+			picker.Remove(peerName)
+		}
+		if picker.GetPeer("Tom") != "" {
+			t.Fatalf("[%s] empty picker should return empty peer", p.name)
+		}
+	}
+}
+
+// TestPeerPicker_Deterministic 结果只取决于peer集合 与注册顺序无关
+func TestPeerPicker_Deterministic(t *testing.T) {
+	peers := peerNames(8)
+	for _, p := range pickers {
+		a, b := p.new(), p.new()
+		a.Register(peers...)
+		for i := len(peers) - 1; i >= 0; i-- {
+			b.Register(peers[i])
+		}
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i)
+			if a.GetPeer(key) != b.GetPeer(key) {
+				t.Fatalf("[%s] key %s maps to different peers", p.name, key)
+			}
+		}
+	}
+}
+
+// TestMaglev_TableSize 非质数的查找表大小被向上取为质数 填充查找表不会陷入死循环
+func TestMaglev_TableSize(t *testing.T) {
+	for size, expect := range map[uint64]uint64{1: 2, 2: 2, 100: 101, 65536: 65537} {
+		m := NewMaglev(size, nil)
+		m.Register(peerNames(3)...)
+		if m.tableSize != expect || uint64(len(m.table)) != expect {
+			t.Fatalf("Actual: %d\tExpect: %d", m.tableSize, expect)
+		}
+		if m.GetPeer("Tom") == "" {
+			t.Fatalf("table of size %d should map keys to peers", size)
+		}
+	}
+}
+
+func BenchmarkPeerPicker_GetPeer(b *testing.B) {
+	for _, p := range pickers {
+		for _, peersNum := range []int{8, 64} {
+			b.Run(fmt.Sprintf("%s/%d", p.name, peersNum), func(b *testing.B) {
+				picker := p.new()
+				picker.Register(peerNames(peersNum)...)
+				keys := make([]string, 1024)
+				for i := range keys {
+					keys[i] = strconv.Itoa(i)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					picker.GetPeer(keys[i&1023])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPeerPicker_Register(b *testing.B) {
+	for _, p := range pickers {
+		b.Run(p.name, func(b *testing.B) {
+			peers := peerNames(64)
+			for i := 0; i < b.N; i++ {
+				picker := p.new()
+				picker.Register(peers...)
+			}
+		})
+	}
+}
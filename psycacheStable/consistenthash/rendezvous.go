@@ -0,0 +1,59 @@
+package consistenthash
+
+// Rendezvous 实现最高随机权重(HRW)哈希
+// 每个key对所有peer打分 得分最高的peer负责该key
+// peer变化时只有被移除/新加入的peer涉及的key会迁移 但每次查找需要遍历所有peer
+type Rendezvous struct {
+	hash  Hash64Func
+	peers map[string]uint64 // peerName -> 预先计算好的peer哈希值
+	names []string          // 排好序的peer 保证得分相同时结果确定
+}
+
+// NewRendezvous 创建一个rendezvous哈希 fn为空时使用FNV-1a
+func NewRendezvous(fn Hash64Func) *Rendezvous {
+	if fn == nil {
+		fn = fnv64a
+	}
+	return &Rendezvous{
+		hash:  fn,
+		peers: make(map[string]uint64),
+	}
+}
+
+// Register 将peer加入候选集合
+func (r *Rendezvous) Register(peersName ...string) {
+	for _, peerName := range peersName {
+		if _, ok := r.peers[peerName]; ok {
+			continue
+		}
+		r.peers[peerName] = r.hash([]byte(peerName))
+	}
+	r.names = sortedPeers(r.peers)
+}
+
+// Remove 将peer移出候选集合
+func (r *Rendezvous) Remove(peerName string) {
+	if _, ok := r.peers[peerName]; !ok {
+		return
+	}
+	delete(r.peers, peerName)
+	r.names = sortedPeers(r.peers)
+}
+
+// GetPeer 返回对key得分最高的peer
+func (r *Rendezvous) GetPeer(key string) string {
+	keyHash := r.hash([]byte(key))
+	var best string
+	var bestScore uint64
+	for _, peerName := range r.names {
+		if score := mix64(keyHash ^ r.peers[peerName]); best == "" || score > bestScore {
+			best, bestScore = peerName, score
+		}
+	}
+	return best
+}
+
+// Peers 返回所有peer
+func (r *Rendezvous) Peers() []string {
+	return append([]string(nil), r.names...)
+}
@@ -0,0 +1,242 @@
+package psycache
+
+// batch 模块提供批量获取/删除缓存的能力
+// 未命中的key按负责的节点分组 每个节点只发送一次RPC 本节点负责的key可交给 BatchRetriever 一次性取回
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchRetriever 要求对象实现从数据源批量获取数据的能力
+// 数据源中不存在的key不应出现在结果中 这些key会被负缓存
+type BatchRetriever interface {
+	retrieveMany(context.Context, []string) (map[string][]byte, error)
+}
+
+// BatchRetrieverFunc 将批量查询函数转换为 BatchRetriever
+// 它同时实现了 Retriever 接口 因此可以直接作为 NewGroup 的retriever使用
+type BatchRetrieverFunc func(ctx context.Context, keys []string) (map[string][]byte, error)
+
+func (f BatchRetrieverFunc) retrieveMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return f(ctx, keys)
+}
+
+func (f BatchRetrieverFunc) retrieve(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	values, err := f(ctx, []string{key})
+	if err != nil {
+		return nil, 0, err
+	}
+	value, ok := values[key]
+	if !ok {
+		return nil, 0, notFound(key)
+	}
+	return value, 0, nil
+}
+
+// GetMany 批量获取keys对应的缓存值 取回失败的key不会出现在结果中
+// 未命中的key按负责的节点分组 每个节点只发送一次RPC 节点不可达时退回本地获取
+// ctx被取消时返回已取到的值与ctx.Err()
+func (g *Group) GetMany(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values, _, err := g.getMany(ctx, keys)
+	return values, err
+}
+
+// getMany 与 GetMany 相同 同时返回确认在数据源中不存在的key 供MultiGet告知调用方
+// 与单个key的Get一致 远端确认不存在的key也会在本地负缓存
+func (g *Group) getMany(ctx context.Context, keys []string) (_ map[string]ByteView, notFound []string, err error) {
+	ctx, span := g.startSpan(ctx, "psycache.Group.GetMany", attrKeys.Int(len(keys)))
+	defer func() { endSpan(span, err) }()
+
+	values := make(map[string]ByteView, len(keys))
+	seen := make(map[string]bool, len(keys))
+	var missing []string
+	for _, key := range keys {
+		if key == "" {
+			return nil, nil, fmt.Errorf("key required")
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		g.stats.gets.Add(1)
+		if value, ok := g.lookupCache(key); ok {
+			values[key] = value
+			continue
+		}
+		if g.lookupNegative(key) {
+			notFound = append(notFound, key)
+			continue
+		}
+		// 与Get相同 布隆过滤器在按节点分组之前生效
+		if g.bloomRejects(key) {
+			notFound = append(notFound, key)
+			continue
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		return values, notFound, nil
+	}
+
+	var mu sync.Mutex
+	var local []string
+	byPeer := make(map[Fetcher][]string)
+	for _, key := range missing {
+		if g.server != nil {
+			if fetcher, ok := g.server.Pick(key); ok {
+				byPeer[fetcher] = append(byPeer[fetcher], key)
+				continue
+			}
+		}
+		local = append(local, key)
+	}
+	if len(byPeer) > 0 {
+		var wg sync.WaitGroup
+		for fetcher, peerKeys := range byPeer {
+			wg.Add(1)
+			go func(fetcher Fetcher, peerKeys []string) {
+				defer wg.Done()
+				start := time.Now()
+				spanCtx, span := g.startSpan(ctx, "psycache.peer.FetchMany", attrKeys.Int(len(peerKeys)))
+				bytes, peerNotFound, err := fetcher.FetchMany(spanCtx, g.name, peerKeys)
+				endSpan(span, err)
+				observeLoad(g.name, sourcePeer, start)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					g.stats.peerErrors.Add(int64(len(peerKeys)))
+					g.logger.Warn("get many from peer failed, load locally", "group", g.name, "keys", len(peerKeys), "err", err)
+					local = append(local, peerKeys...)
+					return
+				}
+				g.stats.peerLoads.Add(int64(len(bytes) + len(peerNotFound)))
+				for key, b := range bytes {
+					value := ByteView{b: cloneBytes(b)}
+					g.populateHotCache(key, value)
+					values[key] = value
+				}
+				for _, key := range peerNotFound {
+					g.populateNegative(key)
+				}
+				notFound = append(notFound, peerNotFound...)
+			}(fetcher, peerKeys)
+		}
+		wg.Wait()
+	}
+	notFound = append(notFound, g.getManyLocally(ctx, local, values)...)
+	return values, notFound, ctx.Err()
+}
+
+// getManyLocally 本地取回keys并填充缓存 结果写入values 返回数据源中不存在的key
+// 配置了 BatchRetriever 时一次性取回 否则逐个key并发经由singleflight取回
+func (g *Group) getManyLocally(ctx context.Context, keys []string, values map[string]ByteView) (notFound []string) {
+	if len(keys) == 0 || ctx.Err() != nil {
+		return nil
+	}
+	if g.batchRetriever != nil {
+		start := time.Now()
+		spanCtx, span := g.startSpan(ctx, "psycache.BatchRetriever", attrKeys.Int(len(keys)))
+		bytes, err := g.batchRetriever.retrieveMany(spanCtx, keys)
+		endSpan(span, err)
+		observeLoad(g.name, sourceRetriever, start)
+		if err != nil {
+			g.stats.retrieverErrors.Add(int64(len(keys)))
+			g.logger.Warn("batch retrieve failed", "group", g.name, "keys", len(keys), "err", err)
+			return nil
+		}
+		g.stats.retrieverLoads.Add(int64(len(bytes)))
+		for _, key := range keys {
+			b, ok := bytes[key]
+			if !ok {
+				g.populateNegative(key)
+				notFound = append(notFound, key)
+				continue
+			}
+			value := ByteView{b: cloneBytes(b)}
+			g.populateCache(key, value, g.expireAt(0), time.Since(start))
+			values[key] = value
+		}
+		return notFound
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			view, err, shared := g.flight.FlyShared(ctx, key, func(ctx context.Context) (interface{}, error) {
+				return g.getLocally(ctx, key)
+			})
+			if shared {
+				g.stats.dedupedLoads.Add(1)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, ErrNotFound) {
+				notFound = append(notFound, key)
+			}
+			if err != nil {
+				return
+			}
+			values[key] = view.(ByteView)
+		}(key)
+	}
+	wg.Wait()
+	return notFound
+}
+
+// RemoveMany 批量删除keys对应的缓存 本地副本直接删除
+// 由其他节点负责的key按节点分组 每个节点只发送一次RPC
+// 配置了 Writer 时先从数据源删除 write-through模式下删除失败则不删除缓存
+func (g *Group) RemoveMany(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if key == "" {
+			return fmt.Errorf("key required")
+		}
+	}
+	for _, key := range keys {
+		if err := g.deleteFromSource(ctx, key); err != nil {
+			return err
+		}
+	}
+	return g.removeManyCache(ctx, keys)
+}
+
+// removeManyCache 批量删除本地与负责各key的节点上的缓存 不经过 Writer
+func (g *Group) removeManyCache(ctx context.Context, keys []string) error {
+	byPeer := make(map[Fetcher][]string)
+	for _, key := range keys {
+		if key == "" {
+			return fmt.Errorf("key required")
+		}
+		g.removeHotCache(key)
+		g.cache.remove(key)
+		if g.server != nil {
+			if fetcher, ok := g.server.Pick(key); ok {
+				byPeer[fetcher] = append(byPeer[fetcher], key)
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+	for fetcher, peerKeys := range byPeer {
+		wg.Add(1)
+		go func(fetcher Fetcher, peerKeys []string) {
+			defer wg.Done()
+			if err := fetcher.RemoveMany(ctx, g.name, peerKeys); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(fetcher, peerKeys)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -5,21 +5,29 @@ package psycache
 import (
 	"errors"
 	cacheAlg "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/arc"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/clock"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/clockpro"
 	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/fifo"
 	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lfu"
 	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lru"
 	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lruk"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/s3fifo"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/tinylfu"
 	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/twoQ"
 	"sync"
+	"time"
 )
 
 // 这样设计可以进行cache和算法的分离，比如我现在实现了lfu缓存模块
 // 只需替换cache成员即可
 type cache struct {
-	mu             sync.Mutex
-	specificCache  Cache
-	capacity       int64 // 缓存最大容量
-	expirationTime int64
+	mu            sync.Mutex
+	specificCache Cache
+	capacity      int64         // 缓存最大容量
+	stopSweep     chan struct{} // 关闭后后台清理协程退出 为nil时没有开启
+	sweepDone     chan struct{} // 后台清理协程退出时关闭
+	concurrent    bool          // 缓存算法自身并发安全 读取时不再加mu 多个读者可以并行
 }
 
 func newLRUCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
@@ -36,10 +44,10 @@ func newLFUCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
 	}
 }
 
-func newLRUKCache(capacity int64, k int, callback cacheAlg.OnEliminated) *cache {
+func newLRUKCache(capacity int64, k int, callback cacheAlg.OnEliminated, opts ...lruk.Option) *cache {
 	return &cache{
 		capacity:      capacity,
-		specificCache: lruk.New(capacity, k, callback),
+		specificCache: lruk.New(capacity, k, callback, opts...),
 	}
 }
 
@@ -50,34 +58,103 @@ func newFIFOCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
 	}
 }
 
-func newtwoQCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
+func newtwoQCache(capacity int64, callback cacheAlg.OnEliminated, opts ...twoQ.Option) *cache {
 	return &cache{
 		capacity:      capacity,
-		specificCache: twoQ.New(capacity, callback),
+		specificCache: twoQ.New(capacity, callback, opts...),
 	}
 }
 
-func (c *cache) add(key string, value ByteView, expiretionTime int64) error {
+func newTinyLFUCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
+	return &cache{
+		capacity:      capacity,
+		specificCache: tinylfu.New(capacity, callback),
+	}
+}
+
+func newARCCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
+	return &cache{
+		capacity:      capacity,
+		specificCache: arc.New(capacity, callback),
+	}
+}
+
+func newClockCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
+	return &cache{
+		capacity:      capacity,
+		specificCache: clock.New(capacity, callback),
+		concurrent:    true,
+	}
+}
+
+func newClockProCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
+	return &cache{
+		capacity:      capacity,
+		specificCache: clockpro.New(capacity, callback),
+		concurrent:    true,
+	}
+}
+
+func newS3FIFOCache(capacity int64, callback cacheAlg.OnEliminated) *cache {
+	return &cache{
+		capacity:      capacity,
+		specificCache: s3fifo.New(capacity, callback),
+		concurrent:    true,
+	}
+}
+
+// entry 是缓存中实际存放的值
+// expire是值的逻辑过期时刻 缓存算法中的过期时刻可以晚于它 这样过期的值能在宽限期内继续提供服务
+type entry struct {
+	value  ByteView
+	expire time.Time     // 零值代表永不过期
+	delta  time.Duration // 加载该值的耗时 由Set写入的值为0
+}
+
+func (e entry) Len() int {
+	return e.value.Len()
+}
+
+// stale 判断值是否已经过了逻辑过期时刻
+func (e entry) stale(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
+}
+
+func (c *cache) add(key string, value ByteView, expirationTime time.Time) error {
+	return c.put(key, entry{value: value, expire: expirationTime}, expirationTime)
+}
+
+// put 存入e 缓存算法在expirationTime时才删除它
+func (c *cache) put(key string, e entry, expirationTime time.Time) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.specificCache == nil {
 		return errors.New("you should build cache first")
 	}
-	c.specificCache.Add(key, value, expiretionTime)
+	c.specificCache.Add(key, e, expirationTime)
 	return nil
 }
 
 func (c *cache) get(key string) (ByteView, bool) {
+	e, ok := c.lookup(key)
+	return e.value, ok
+}
+
+// lookup 返回key对应的entry 包括已过逻辑过期时刻但仍在宽限期内的值
+func (c *cache) lookup(key string) (entry, bool) {
 	if c.specificCache == nil {
-		return ByteView{}, false
+		return entry{}, false
 	}
 	// 注意：Get操作需要修改lru中的双向链表，需要使用互斥锁。
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// CLOCK等算法的读路径只设置引用位 自身用读写锁保护 无需再加锁
+	if !c.concurrent {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
 	if v, ok := c.specificCache.Get(key); ok {
-		return v.(ByteView), true
+		return v.(entry), true
 	}
-	return ByteView{}, false
+	return entry{}, false
 }
 
 func (c *cache) remove(key string) bool {
@@ -97,8 +174,75 @@ func (c *cache) contains(key string) bool {
 	if c.specificCache == nil {
 		return false
 	}
+	// Contains会删除过期的条目 同样需要加锁
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if ok := c.specificCache.Contains(key); ok {
 		return true
 	}
 	return false
 }
+
+// stats 返回缓存算法报告的统计信息 算法未实现 cacheAlg.StatsReporter 时返回零值
+func (c *cache) stats() cacheAlg.Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if reporter, ok := c.specificCache.(cacheAlg.StatsReporter); ok {
+		return reporter.Stats()
+	}
+	return cacheAlg.Stats{}
+}
+
+const (
+	sweepSample         = 20 // 每轮抽样检查的条目数
+	sweepRepeatFraction = 4  // 一轮中过期的条目超过1/4时 认为还有大量过期条目 继续下一轮
+)
+
+// startSweeper 每隔interval主动清理一次过期条目 缓存算法需实现 cacheAlg.Sweeper
+// 每次清理仿照Redis的定期删除: 抽样检查sweepSample个条目 过期比例高时继续抽样 直至用完budget
+// 每轮之间会释放锁 不会长时间阻塞读写
+func (c *cache) startSweeper(interval, budget time.Duration) {
+	sweeper, ok := c.specificCache.(cacheAlg.Sweeper)
+	if !ok || interval <= 0 || c.stopSweep != nil {
+		return
+	}
+	c.stopSweep = make(chan struct{})
+	c.sweepDone = make(chan struct{})
+	stop, done := c.stopSweep, c.sweepDone
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.sweep(sweeper, budget)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// sweep 执行一次清理
+func (c *cache) sweep(sweeper cacheAlg.Sweeper, budget time.Duration) {
+	start := time.Now()
+	for {
+		c.mu.Lock()
+		checked, expired := sweeper.SweepExpired(sweepSample)
+		c.mu.Unlock()
+		if checked == 0 || expired*sweepRepeatFraction <= checked || time.Since(start) >= budget {
+			return
+		}
+	}
+}
+
+// stopSweeper 停止后台清理 等待正在进行的清理结束后返回
+func (c *cache) stopSweeper() {
+	if c.stopSweep != nil {
+		close(c.stopSweep)
+		<-c.sweepDone
+		c.stopSweep = nil
+		c.sweepDone = nil
+	}
+}
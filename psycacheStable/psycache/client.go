@@ -2,78 +2,225 @@ package psycache
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/Psychopath-H/psycache-master/psycacheStable/registry"
 	pb "psycachepb"
+	"sync"
 	"time"
 
-	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
 )
 
 // client 模块实现psycache访问其他远程节点 从而获取缓存和删除缓存
 
+// defaultRPCTimeout 调用方未设置deadline时 单次RPC的超时时间
+const defaultRPCTimeout = 10 * time.Second
+
+// errClientClosed 表示client已被关闭 通常是该节点已从哈希环上移除
+var errClientClosed = errors.New("psycache: client closed")
+
+// client 与远端节点维持一条长连接 连接在第一次使用时建立 之后被所有请求复用
+// grpc.ClientConn 会在连接断开后自动重连 因此无需每次请求都重新拨号
 type client struct {
-	name string // 服务名称 pcache/ip:addr
+	addr     string // 远端节点地址 x.x.x.x:port
+	dialOpts []grpc.DialOption
+	mu       sync.Mutex
+	conn     *grpc.ClientConn
+	closed   bool // Close之后不再建立连接
 }
 
 // Fetch 从remote peer获取对应缓存值
-func (c *client) Fetch(group string, key string) ([]byte, error) {
-	// 创建一个etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
-	if err != nil {
-		return nil, err
-	}
-	defer cli.Close()
-	// 发现服务 取得与服务的连接
-	conn, err := registry.EtcdDial(cli, c.name)
+func (c *client) Fetch(ctx context.Context, group string, key string) ([]byte, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	conn, err := c.getConn()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 	grpcClient := pb.NewPsyCacheClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	start := time.Now()
 	resp, err := grpcClient.Get(ctx, &pb.GetRequest{
 		Group: group,
 		Key:   key,
 	})
+	if status.Code(err) == codes.NotFound {
+		observeRPC(c.addr, "Get", start, nil)
+		return nil, notFound(key)
+	}
+	observeRPC(c.addr, "Get", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("could not get %s/%s from peer %s", group, key, c.name)
+		c.checkErr(conn, err)
+		return nil, fmt.Errorf("could not get %s/%s from peer %s: %w", group, key, c.addr, err)
 	}
 
 	return resp.GetValue(), nil
 }
 
 // Remove 从remote peer删除对应缓存值
-func (c *client) Remove(group string, key string) error {
-	// 创建一个etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
+func (c *client) Remove(ctx context.Context, group string, key string) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	conn, err := c.getConn()
 	if err != nil {
 		return err
 	}
-	defer cli.Close()
-	// 发现服务 取得与服务的连接
-	conn, err := registry.EtcdDial(cli, c.name)
+	grpcClient := pb.NewPsyCacheClient(conn)
+	start := time.Now()
+	_, err = grpcClient.Remove(ctx, &pb.GetRequest{
+		Group: group,
+		Key:   key,
+	})
+	observeRPC(c.addr, "Remove", start, err)
+	if err != nil {
+		c.checkErr(conn, err)
+		return fmt.Errorf("could not remove %s/%s from peer %s: %w", group, key, c.addr, err)
+	}
+
+	return nil
+}
+
+// Set 将缓存值写入remote peer ttl小于等于0时使用对端group的默认过期时间
+func (c *client) Set(ctx context.Context, group string, key string, value []byte, ttl time.Duration) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	conn, err := c.getConn()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
 	grpcClient := pb.NewPsyCacheClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	_, err = grpcClient.Remove(ctx, &pb.GetRequest{
+	start := time.Now()
+	_, err = grpcClient.Set(ctx, &pb.SetRequest{
 		Group: group,
 		Key:   key,
+		Value: value,
+		Ttl:   ttlMillis(ttl),
 	})
+	observeRPC(c.addr, "Set", start, err)
 	if err != nil {
-		return fmt.Errorf("could not get %s/%s from peer %s", group, key, c.name)
+		c.checkErr(conn, err)
+		return fmt.Errorf("could not set %s/%s to peer %s: %w", group, key, c.addr, err)
 	}
 
 	return nil
 }
 
-func NewClient(service string) *client {
-	return &client{name: service}
+// FetchMany 从remote peer批量获取缓存值 取回失败的key不会出现在结果中
+// 远端确认不存在的key由notFound返回
+func (c *client) FetchMany(ctx context.Context, group string, keys []string) (map[string][]byte, []string, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	conn, err := c.getConn()
+	if err != nil {
+		return nil, nil, err
+	}
+	grpcClient := pb.NewPsyCacheClient(conn)
+	start := time.Now()
+	resp, err := grpcClient.MultiGet(ctx, &pb.MultiGetRequest{
+		Group: group,
+		Keys:  keys,
+	})
+	observeRPC(c.addr, "MultiGet", start, err)
+	if err != nil {
+		c.checkErr(conn, err)
+		return nil, nil, fmt.Errorf("could not get %d keys of %s from peer %s: %w", len(keys), group, c.addr, err)
+	}
+
+	return resp.GetValues(), resp.GetNotFound(), nil
+}
+
+// RemoveMany 从remote peer批量删除缓存值
+func (c *client) RemoveMany(ctx context.Context, group string, keys []string) error {
+	ctx, cancel := withDefaultTimeout(ctx)
+	defer cancel()
+	conn, err := c.getConn()
+	if err != nil {
+		return err
+	}
+	grpcClient := pb.NewPsyCacheClient(conn)
+	start := time.Now()
+	_, err = grpcClient.MultiRemove(ctx, &pb.MultiRemoveRequest{
+		Group: group,
+		Keys:  keys,
+	})
+	observeRPC(c.addr, "MultiRemove", start, err)
+	if err != nil {
+		c.checkErr(conn, err)
+		return fmt.Errorf("could not remove %d keys of %s from peer %s: %w", len(keys), group, c.addr, err)
+	}
+
+	return nil
+}
+
+// ttlMillis 将ttl向上取整为毫秒 不足1毫秒的正ttl不会变成0 从而被对端当作使用默认过期时间
+func ttlMillis(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// withDefaultTimeout 若ctx没有deadline 则为其加上默认的RPC超时时间
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, defaultRPCTimeout)
+}
+
+// getConn 返回与远端节点的长连接 连接不存在或已断开时重新建立 client被关闭后返回错误
+// 节点地址已由服务发现给出 无需再经过etcd解析
+func (c *client) getConn() (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, fmt.Errorf("peer %s: %w", c.addr, errClientClosed)
+	}
+	if c.conn != nil && c.conn.GetState() != connectivity.Shutdown {
+		return c.conn, nil
+	}
+	// 非阻塞拨号 连接在后台建立 请求会等待连接就绪
+	// 请求会携带调用方ctx中的trace context 使远端的span与本地串成同一条链路
+	opts := append([]grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(injectTraceContext),
+	}, c.dialOpts...)
+	conn, err := grpc.Dial(c.addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// checkErr 在远端不可达时跳过重连退避 让下一次请求立刻尝试重连
+func (c *client) checkErr(conn *grpc.ClientConn, err error) {
+	if status.Code(err) == codes.Unavailable {
+		conn.ResetConnectBackoff()
+	}
+}
+
+// Close 关闭与远端节点的连接 之后的请求直接返回错误 不再重新建立连接
+// SetPeers 移除节点时调用 此时仍持有该client的请求不会再泄漏一条新连接
+func (c *client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// NewClient 创建访问远端节点addr的client opts为空时使用不加密的连接
+func NewClient(addr string, opts ...grpc.DialOption) *client {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	return &client{addr: addr, dialOpts: opts}
 }
 
 // 测试Client是否实现了Fetcher接口
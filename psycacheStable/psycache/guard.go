@@ -0,0 +1,152 @@
+package psycache
+
+// guard 模块在数据源前放置布隆过滤器 适用于key集合可以枚举的数据源
+// 过滤器判定一定不存在的key在进入singleflight之前就被拒绝 不会访问远端peer与 Retriever
+// 过滤器只知道构建时枚举出的key与之后经由本节点 Set 写入的key
+// 其他途径新增的key(包括经由其他节点 Set 写入的key)需等到本节点下一次重建后才能被访问
+// 因此数据源会新增key时应配置周期重建
+
+import (
+	"context"
+	"errors"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/bloom"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyEnumerator 枚举数据源中的全部key 对每个key调用一次add
+type KeyEnumerator func(ctx context.Context, add func(key string)) error
+
+// BloomConfig 是布隆过滤器的配置
+type BloomConfig struct {
+	Enumerate         KeyEnumerator // 构建过滤器时枚举key 必须指定
+	ExpectedKeys      int           // 预期的key数 为0时按枚举出的key数再预留1/4
+	FalsePositiveRate float64       // 假阳性率 默认0.01
+	RebuildInterval   time.Duration // 周期重建的间隔 为0时只在 NewGroup 与 RebuildBloomFilter 时构建
+}
+
+// ErrBloomFilterDisabled 在没有配置布隆过滤器时调用 RebuildBloomFilter 返回
+var ErrBloomFilterDisabled = errors.New("psycache: bloom filter not configured")
+
+// bloomGuard 持有当前使用的过滤器 重建时整体替换
+type bloomGuard struct {
+	config  BloomConfig
+	filter  atomic.Pointer[bloom.Filter] // 为nil时放行所有key
+	rebuild sync.Mutex                   // 保证同一时刻只有一次重建
+
+	mu         sync.Mutex // 保护rebuilding与pending
+	rebuilding bool
+	pending    []string // 重建期间写入的key 重建完成后补进新的过滤器
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// RebuildBloomFilter 重新枚举数据源的key并替换布隆过滤器 重建期间仍使用旧的过滤器
+func (g *Group) RebuildBloomFilter(ctx context.Context) error {
+	b := g.bloom
+	if b == nil {
+		return ErrBloomFilterDisabled
+	}
+	b.rebuild.Lock()
+	defer b.rebuild.Unlock()
+
+	b.mu.Lock()
+	b.rebuilding = true
+	b.pending = nil
+	b.mu.Unlock()
+
+	var keys []string
+	err := b.config.Enumerate(ctx, func(key string) {
+		keys = append(keys, key)
+	})
+	if err != nil {
+		b.mu.Lock()
+		b.rebuilding = false
+		b.pending = nil
+		b.mu.Unlock()
+		return err
+	}
+	n := b.config.ExpectedKeys
+	if n <= 0 {
+		n = len(keys) + len(keys)/4
+	}
+	filter := bloom.New(n, b.config.FalsePositiveRate)
+	for _, key := range keys {
+		filter.Add(key)
+	}
+
+	b.mu.Lock()
+	for _, key := range b.pending {
+		filter.Add(key)
+	}
+	b.filter.Store(filter)
+	b.rebuilding = false
+	b.pending = nil
+	b.mu.Unlock()
+	g.logger.Info("bloom filter rebuilt", "group", g.name, "keys", len(keys))
+	return nil
+}
+
+// bloomRejects 返回true时key一定不在数据源中 在选择节点之前调用
+// 每个节点各自持有过滤器 经由其他节点Set写入的key在本节点重建过滤器之前会被拒绝
+func (g *Group) bloomRejects(key string) bool {
+	if g.bloom == nil {
+		return false
+	}
+	filter := g.bloom.filter.Load()
+	if filter == nil || filter.MayContain(key) {
+		return false
+	}
+	g.logger.Debug("rejected by bloom filter", "group", g.name, "key", key)
+	g.stats.bloomRejects.Add(1)
+	return true
+}
+
+// bloomAdd 将写入的key加入布隆过滤器
+func (g *Group) bloomAdd(key string) {
+	b := g.bloom
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if filter := b.filter.Load(); filter != nil {
+		filter.Add(key)
+	}
+	if b.rebuilding {
+		b.pending = append(b.pending, key)
+	}
+}
+
+// startBloomGuard 构建过滤器 并按配置开启周期重建
+func (g *Group) startBloomGuard() {
+	if err := g.RebuildBloomFilter(context.Background()); err != nil {
+		g.logger.Error("build bloom filter failed, all keys are allowed", "group", g.name, "err", err)
+	}
+	if g.bloom.config.RebuildInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(g.bloom.config.RebuildInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := g.RebuildBloomFilter(context.Background()); err != nil {
+					g.logger.Error("rebuild bloom filter failed, keep the old one", "group", g.name, "err", err)
+				}
+			case <-g.bloom.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close 停止周期重建
+func (b *bloomGuard) close() {
+	b.once.Do(func() {
+		close(b.stop)
+	})
+}
@@ -0,0 +1,34 @@
+package psycache
+
+// logger 模块定义了psycache输出日志的方式
+// 每个请求都会经过的路径只输出Debug级别的日志 默认的Logger丢弃所有日志 不影响吞吐
+
+import (
+	"log/slog"
+)
+
+// Logger 定义了分级输出结构化日志的能力 args为成对出现的key与value
+// *slog.Logger 直接实现了该接口
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NewSlogLogger 使用slog输出日志 l为空时使用slog.Default()
+// 级别过滤由l的Handler决定 例如 slog.HandlerOptions{Level: slog.LevelDebug}
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l
+}
+
+// nopLogger 丢弃所有日志 是 Group 与 server 的默认Logger
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
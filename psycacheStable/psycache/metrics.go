@@ -0,0 +1,196 @@
+package psycache
+
+// metrics 模块以Prometheus格式导出缓存与节点间RPC的指标
+// 指标名称是对外承诺的一部分 修改前需同步更新依赖它们的看板与告警:
+//
+//	psycache_group_gets_total{group}                          Get请求的key数
+//	psycache_group_hits_total{group,cache}                    本地命中次数 cache为main、hot或negative
+//	psycache_group_hit_ratio{group}                           本地命中率
+//	psycache_group_loads_total{group,source,result}           未命中时的加载次数 source为peer或retriever result为ok或error
+//	psycache_group_deduped_loads_total{group}                 被singleflight合并的加载次数
+//	psycache_group_bloom_rejects_total{group}                 被布隆过滤器拒绝的key数
+//	psycache_group_load_duration_seconds{group,source}        加载耗时
+//	psycache_cache_bytes{group,cache}                         缓存占用的内存大小
+//	psycache_cache_entries{group,cache}                       缓存的条目数
+//	psycache_cache_evictions_total{group,cache}               因容量不足被淘汰的条目数
+//	psycache_cache_expirations_total{group,cache}             因过期被删除的条目数
+//	psycache_peer_rpc_duration_seconds{peer,method}           访问远端节点的RPC耗时
+//	psycache_peer_rpc_errors_total{peer,method}               访问远端节点失败的RPC次数
+//	psycache_peers{addr}                                      节点addr所见的集群节点数
+
+import (
+	cacheAlg "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const metricsNamespace = "psycache"
+
+// 加载来源
+const (
+	sourcePeer      = "peer"
+	sourceRetriever = "retriever"
+)
+
+var (
+	loadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "group_load_duration_seconds",
+		Help:      "Latency of loading a missing key from a peer or the retriever.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"group", "source"})
+
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "peer_rpc_duration_seconds",
+		Help:      "Latency of gRPC calls to remote peers.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"peer", "method"})
+
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "peer_rpc_errors_total",
+		Help:      "Number of failed gRPC calls to remote peers.",
+	}, []string{"peer", "method"})
+)
+
+// observeLoad 记录一次加载的耗时
+func observeLoad(group, source string, start time.Time) {
+	loadDuration.WithLabelValues(group, source).Observe(time.Since(start).Seconds())
+}
+
+// observeRPC 记录一次访问远端节点的RPC
+func observeRPC(peer, method string, start time.Time, err error) {
+	rpcDuration.WithLabelValues(peer, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(peer, method).Inc()
+	}
+}
+
+// RegisterMetrics 将所有 Group 与节点间RPC的指标注册到reg
+// 使用自己的registry时调用 server配置了 WithMetricsAddr 时无需调用
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{groupsCollector{}, loadDuration, rpcDuration, rpcErrors} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MetricsHandler 返回导出本节点指标的http.Handler 包含所有 Group 与集群节点数
+func (s *server) MetricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	// 全新的registry上注册失败只可能是指标定义有误 与 MustRegister 一样直接panic
+	if err := RegisterMetrics(reg); err != nil {
+		panic(err)
+	}
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "peers",
+		Help:        "Number of peers in the placement ring.",
+		ConstLabels: prometheus.Labels{"addr": s.addr},
+	}, func() float64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.consHash == nil {
+			return 0
+		}
+		return float64(len(s.consHash.Peers()))
+	}))
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// serveMetrics 在metricsAddr上提供/metrics 直到server停止
+func (s *server) serveMetrics() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	srv := &http.Server{Addr: s.metricsAddr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("metrics server stopped", "addr", s.addr, "err", err)
+		}
+	}()
+	return srv
+}
+
+var (
+	groupGetsDesc = prometheus.NewDesc(metricsNamespace+"_group_gets_total",
+		"Number of keys requested through Get and GetMany.", []string{"group"}, nil)
+	groupHitsDesc = prometheus.NewDesc(metricsNamespace+"_group_hits_total",
+		"Number of keys served from the local main, hot or negative cache.", []string{"group", "cache"}, nil)
+	groupHitRatioDesc = prometheus.NewDesc(metricsNamespace+"_group_hit_ratio",
+		"Ratio of keys served locally to keys requested.", []string{"group"}, nil)
+	groupLoadsDesc = prometheus.NewDesc(metricsNamespace+"_group_loads_total",
+		"Number of missing keys loaded from a peer or the retriever.", []string{"group", "source", "result"}, nil)
+	groupDedupedDesc = prometheus.NewDesc(metricsNamespace+"_group_deduped_loads_total",
+		"Number of loads coalesced into an in-flight load by singleflight.", []string{"group"}, nil)
+	groupBloomRejectsDesc = prometheus.NewDesc(metricsNamespace+"_group_bloom_rejects_total",
+		"Number of keys rejected by the bloom filter as certainly missing.", []string{"group"}, nil)
+	cacheBytesDesc = prometheus.NewDesc(metricsNamespace+"_cache_bytes",
+		"Bytes held by the cache.", []string{"group", "cache"}, nil)
+	cacheEntriesDesc = prometheus.NewDesc(metricsNamespace+"_cache_entries",
+		"Number of entries held by the cache.", []string{"group", "cache"}, nil)
+	cacheEvictionsDesc = prometheus.NewDesc(metricsNamespace+"_cache_evictions_total",
+		"Number of entries evicted for capacity.", []string{"group", "cache"}, nil)
+	cacheExpirationsDesc = prometheus.NewDesc(metricsNamespace+"_cache_expirations_total",
+		"Number of entries removed after expiring.", []string{"group", "cache"}, nil)
+)
+
+// groupsCollector 在每次抓取时读取所有 Group 的 Stats
+// 计数器本身由 Group 维护 这里只做转换 因此不会与 Group.Stats 产生偏差
+type groupsCollector struct{}
+
+func (groupsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{groupGetsDesc, groupHitsDesc, groupHitRatioDesc, groupLoadsDesc,
+		groupDedupedDesc, groupBloomRejectsDesc, cacheBytesDesc, cacheEntriesDesc, cacheEvictionsDesc, cacheExpirationsDesc} {
+		ch <- desc
+	}
+}
+
+func (groupsCollector) Collect(ch chan<- prometheus.Metric) {
+	mu.RLock()
+	snapshot := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		snapshot = append(snapshot, g)
+	}
+	mu.RUnlock()
+
+	for _, g := range snapshot {
+		stats := g.Stats()
+		counter := func(desc *prometheus.Desc, v int64, labels ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), append([]string{g.name}, labels...)...)
+		}
+		gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, append([]string{g.name}, labels...)...)
+		}
+
+		counter(groupGetsDesc, stats.Gets)
+		counter(groupHitsDesc, stats.LocalHits-stats.HotHits-stats.NegativeHits, "main")
+		counter(groupHitsDesc, stats.HotHits, "hot")
+		counter(groupHitsDesc, stats.NegativeHits, "negative")
+		var ratio float64
+		if stats.Gets > 0 {
+			ratio = float64(stats.LocalHits) / float64(stats.Gets)
+		}
+		gauge(groupHitRatioDesc, ratio)
+		counter(groupLoadsDesc, stats.PeerLoads, sourcePeer, "ok")
+		counter(groupLoadsDesc, stats.PeerErrors, sourcePeer, "error")
+		counter(groupLoadsDesc, stats.RetrieverLoads, sourceRetriever, "ok")
+		counter(groupLoadsDesc, stats.RetrieverErrors, sourceRetriever, "error")
+		counter(groupDedupedDesc, stats.DedupedLoads)
+		counter(groupBloomRejectsDesc, stats.BloomRejects)
+
+		for _, c := range []struct {
+			name  string
+			stats cacheAlg.Stats
+		}{{"main", stats.MainCache}, {"hot", stats.HotCache}, {"negative", stats.NegCache}} {
+			gauge(cacheBytesDesc, float64(c.stats.Bytes), c.name)
+			gauge(cacheEntriesDesc, float64(c.stats.Entries), c.name)
+			counter(cacheEvictionsDesc, c.stats.Evictions, c.name)
+			counter(cacheExpirationsDesc, c.stats.Expirations, c.name)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package psycache
+
+// negative 模块缓存数据源中不存在的key 防止缓存穿透
+// Retriever 返回 ErrNotFound 时 key会以空值存入独立的负缓存 在negativeTTL内的查询直接返回 ErrNotFound 不再访问数据源
+// 负缓存默认关闭 开启后从maxBytes中划出 不会挤占正常数据 远端节点通过gRPC的NotFound状态码告知key不存在
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultNegativeTTL        = 0        // 负缓存默认关闭 通过 WithNegativeTTL 开启
+	defaultNegativeCacheRatio = 1.0 / 16 // 开启时负缓存占maxBytes的比例
+)
+
+// ErrNotFound 代表数据源中不存在该key Retriever 返回它(或包装了它的错误)时结果会被负缓存
+var ErrNotFound = errors.New("psycache: not found")
+
+// notFound 返回携带key的 ErrNotFound
+func notFound(key string) error {
+	return fmt.Errorf("%w: %s", ErrNotFound, key)
+}
+
+// lookupNegative 查找负缓存 命中代表key在数据源中不存在
+func (g *Group) lookupNegative(key string) bool {
+	if g.negCache == nil {
+		return false
+	}
+	if _, ok := g.negCache.get(key); !ok {
+		return false
+	}
+	g.logger.Debug("negative cache hit", "group", g.name, "key", key)
+	g.stats.localHits.Add(1)
+	g.stats.negativeHits.Add(1)
+	return true
+}
+
+// populateNegative 记录key在数据源中不存在
+func (g *Group) populateNegative(key string) {
+	if g.negCache == nil {
+		return
+	}
+	g.negCache.add(key, ByteView{}, time.Now().Add(g.negativeTTL))
+}
+
+// removeNegative 在key被写入后删除它的负缓存
+func (g *Group) removeNegative(key string) {
+	if g.negCache != nil {
+		g.negCache.remove(key)
+	}
+}
@@ -0,0 +1,298 @@
+package psycache
+
+import (
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lruk"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/twoQ"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/consistenthash"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"time"
+)
+
+// option 模块提供 Group 与 server 的可选配置
+// 采用函数式选项 这样新增配置项不会破坏 NewGroup 与 NewServer 的调用方
+
+// GroupOption 定义了配置 Group 的函数
+type GroupOption func(*Group)
+
+// WithTTLJitter 为每个缓存的过期时长附加[0, jitter)的随机抖动
+// 同一批写入的key因此不会在同一时刻集中过期
+func WithTTLJitter(jitter time.Duration) GroupOption {
+	return func(g *Group) {
+		g.jitter = jitter
+	}
+}
+
+// WithLoadTimeout 指定每次加载(访问peer或Retriever)最多运行的时长 默认为10秒 为0时不限制
+// 并发请求同一个key时共享一次加载 加载的超时与各调用方的deadline无关 调用方只会等待到自己的deadline为止
+func WithLoadTimeout(timeout time.Duration) GroupOption {
+	return func(g *Group) {
+		if timeout >= 0 {
+			g.flight.Timeout = timeout
+		}
+	}
+}
+
+// WithGroupLogger 指定 Group 输出日志的Logger 默认丢弃所有日志
+func WithGroupLogger(logger Logger) GroupOption {
+	return func(g *Group) {
+		if logger != nil {
+			g.logger = logger
+		}
+	}
+}
+
+// WithGroupTracerProvider 指定 Group 创建span使用的TracerProvider
+// 默认沿用所注册server的TracerProvider 两者都没有时不开启链路追踪
+func WithGroupTracerProvider(tp oteltrace.TracerProvider) GroupOption {
+	return func(g *Group) {
+		if tp != nil {
+			g.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
+// WithHotCacheRatio 指定热点缓存占maxBytes的比例 默认为0 即关闭
+// 热点缓存存放从远端peer取回的数据 单节点部署时无需开启
+func WithHotCacheRatio(ratio float64) GroupOption {
+	return func(g *Group) {
+		if ratio >= 0 && ratio < 1 {
+			g.hotCacheRatio = ratio
+		}
+	}
+}
+
+// WithHotCacheSampleRate 指定每rate次从远端取回数据 抽取1次放入热点缓存 默认为10
+// rate为1时每次都放入
+func WithHotCacheSampleRate(rate int) GroupOption {
+	return func(g *Group) {
+		if rate > 0 {
+			g.hotCacheSampleRate = rate
+		}
+	}
+}
+
+// WithHotCacheTTL 指定热点副本的存活时长 默认为10秒 实际不超过group ttl的1/10
+// 负责节点上的Set/Remove无法让其他节点上的副本失效 因此ttl越短 读到旧值的时间越短
+func WithHotCacheTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		if ttl > 0 {
+			g.hotCacheTTL = ttl
+		}
+	}
+}
+
+// WithNegativeTTL 指定 Retriever 返回 ErrNotFound 的key在负缓存中的存活时长 ttl大于0时开启负缓存
+// 负缓存从maxBytes中划出1/16 默认关闭 每次查询不存在的key都会访问数据源
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		if ttl >= 0 {
+			g.negativeTTL = ttl
+		}
+	}
+}
+
+// WithBloomFilter 在数据源前放置由config.Enumerate构建的布隆过滤器
+// 一定不存在的key直接返回 ErrNotFound NewGroup 会同步构建一次 构建失败时放行所有key
+func WithBloomFilter(config BloomConfig) GroupOption {
+	return func(g *Group) {
+		if config.Enumerate != nil {
+			g.bloom = &bloomGuard{config: config, stop: make(chan struct{})}
+		}
+	}
+}
+
+// WithStaleWhileRevalidate 开启stale-while-revalidate 值过期后的window内仍返回旧值
+// 同时在后台重新加载一次 加载完成前的访问不会阻塞 window为0时关闭
+func WithStaleWhileRevalidate(window time.Duration) GroupOption {
+	return func(g *Group) {
+		if window >= 0 {
+			g.staleWindow = window
+		}
+	}
+}
+
+// WithRefreshAhead 开启refresh-ahead 值距过期不足window时被访问 则提前在后台重新加载
+// 只有过期前仍被访问的key会被刷新 冷key照常过期 window为0时关闭
+func WithRefreshAhead(window time.Duration) GroupOption {
+	return func(g *Group) {
+		if window >= 0 {
+			g.refreshAhead = window
+		}
+	}
+}
+
+// WithEarlyExpiration 开启XFetch提前过期 值越接近过期、加载耗时越长 被访问时越可能提前在后台刷新
+// beta越大刷新越早 通常取1 beta为0时关闭
+func WithEarlyExpiration(beta float64) GroupOption {
+	return func(g *Group) {
+		if beta >= 0 {
+			g.xfetchBeta = beta
+		}
+	}
+}
+
+// WithExpirationSweeper 每隔interval在后台主动清理已过期的缓存 每次最多占用budget
+// budget为0时取interval的1/4 默认不开启 过期的缓存只在被访问或被淘汰时删除
+func WithExpirationSweeper(interval, budget time.Duration) GroupOption {
+	return func(g *Group) {
+		if interval <= 0 {
+			return
+		}
+		if budget <= 0 {
+			budget = interval / 4
+		}
+		g.sweepInterval = interval
+		g.sweepBudget = budget
+	}
+}
+
+// WithTwoQKin 指定TYPE_2Q中A1in占主缓存容量的比例 取值在(0,1)之间 默认为0.25
+// 对其他缓存算法不生效
+func WithTwoQKin(ratio float64) GroupOption {
+	return func(g *Group) {
+		g.twoQOpts = append(g.twoQOpts, twoQ.WithKin(ratio))
+	}
+}
+
+// WithTwoQKout 指定TYPE_2Q中A1out最多记录的key数与常驻条目数之比 必须大于0 默认为0.5
+// 对其他缓存算法不生效
+func WithTwoQKout(ratio float64) GroupOption {
+	return func(g *Group) {
+		g.twoQOpts = append(g.twoQOpts, twoQ.WithKout(ratio))
+	}
+}
+
+// WithLRUKCorrelatedReferencePeriod 指定TYPE_LRUK的相关访问周期 默认为0
+// 与上一次访问间隔不超过d的访问不增加访问次数 对其他缓存算法不生效
+func WithLRUKCorrelatedReferencePeriod(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.lrukOpts = append(g.lrukOpts, lruk.WithCorrelatedReferencePeriod(d))
+	}
+}
+
+// WithLRUKHistoryRatio 指定TYPE_LRUK保留访问记录的已淘汰key数与常驻条目数之比 必须大于0 默认为1
+// 对其他缓存算法不生效
+func WithLRUKHistoryRatio(ratio float64) GroupOption {
+	return func(g *Group) {
+		g.lrukOpts = append(g.lrukOpts, lruk.WithHistoryRatio(ratio))
+	}
+}
+
+// WithBatchRetriever 指定批量取回数据的方式 GetMany中由本节点负责的未命中key会一次性交给它
+// retriever本身实现了 BatchRetriever 时无需指定
+func WithBatchRetriever(retriever BatchRetriever) GroupOption {
+	return func(g *Group) {
+		g.batchRetriever = retriever
+	}
+}
+
+// WithWriteThrough 指定 Group 同步写回数据源的 Writer
+// Set/Remove 先调用 Writer 成功后才更新缓存 失败时返回错误且缓存保持不变
+func WithWriteThrough(writer Writer) GroupOption {
+	return func(g *Group) {
+		g.writer = writer
+		g.writeBehindConfig = nil
+	}
+}
+
+// WithWriteBehind 指定 Group 异步写回数据源的 Writer
+// Set/Remove 的写操作进入有界队列后立即更新缓存 由后台协程按config攒批写回
+// 可调用 Group.Flush 等待队列写完 DestroyGroup 也会在写完队列后返回
+func WithWriteBehind(writer Writer, config WriteBehindConfig) GroupOption {
+	return func(g *Group) {
+		g.writer = writer
+		g.writeBehindConfig = &config
+	}
+}
+
+// ServerOption 定义了配置 server 的函数
+type ServerOption func(*server)
+
+// WithListenAddr 指定server实际监听的地址 例如"127.0.0.1:6324"
+// 默认监听addr端口上的所有网卡
+func WithListenAddr(addr string) ServerOption {
+	return func(s *server) {
+		s.listenAddr = addr
+	}
+}
+
+// WithServiceName 指定注册至etcd时使用的服务名 默认为"psycache"
+func WithServiceName(name string) ServerOption {
+	return func(s *server) {
+		s.serviceName = name
+	}
+}
+
+// WithEtcdEndpoints 指定注册服务时使用的etcd地址 默认为"localhost:2379"
+func WithEtcdEndpoints(endpoints ...string) ServerOption {
+	return func(s *server) {
+		s.etcdConfig.Endpoints = endpoints
+	}
+}
+
+// WithEtcdConfig 指定注册服务时使用的完整etcd配置
+func WithEtcdConfig(config clientv3.Config) ServerOption {
+	return func(s *server) {
+		s.etcdConfig = config
+	}
+}
+
+// WithReplicas 指定一致性哈希中每个节点的虚拟节点个数 默认为50
+func WithReplicas(replicas int) ServerOption {
+	return func(s *server) {
+		if replicas > 0 {
+			s.replicas = replicas
+		}
+	}
+}
+
+// WithPeerPicker 指定key到节点的映射策略 例如 consistenthash.NewRendezvous/NewJump/NewMaglev/NewBounded
+// newPicker在每次重建节点列表时调用 需返回一个新的实例 指定后WithReplicas不再生效
+func WithPeerPicker(newPicker func() consistenthash.PeerPicker) ServerOption {
+	return func(s *server) {
+		s.newPicker = newPicker
+	}
+}
+
+// WithServerTracerProvider 指定 server 创建span使用的TracerProvider 默认不开启链路追踪
+// 注册到该server的 Group 若没有通过 WithGroupTracerProvider 单独指定 也会沿用它
+func WithServerTracerProvider(tp oteltrace.TracerProvider) ServerOption {
+	return func(s *server) {
+		s.tracerProvider = tp
+	}
+}
+
+// WithServerLogger 指定 server 输出日志的Logger 默认丢弃所有日志
+func WithServerLogger(logger Logger) ServerOption {
+	return func(s *server) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
+
+// WithMetricsAddr 在addr上以Prometheus格式提供/metrics 例如":9090" 默认不开启
+func WithMetricsAddr(addr string) ServerOption {
+	return func(s *server) {
+		s.metricsAddr = addr
+	}
+}
+
+// WithTLS 指定节点间通信使用的TLS证书
+// serverCreds用于本节点对外提供服务 clientCreds用于访问其他节点
+func WithTLS(serverCreds, clientCreds credentials.TransportCredentials) ServerOption {
+	return func(s *server) {
+		s.serverCreds = serverCreds
+		s.clientCreds = clientCreds
+	}
+}
+
+// WithGRPCServerOptions 追加创建grpc server时的配置 例如消息大小限制/拦截器
+func WithGRPCServerOptions(opts ...grpc.ServerOption) ServerOption {
+	return func(s *server) {
+		s.grpcOpts = append(s.grpcOpts, opts...)
+	}
+}
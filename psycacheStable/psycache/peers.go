@@ -1,7 +1,9 @@
 package psycache
 
 import (
+	"context"
 	cacheALg "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"time"
 )
 
 // peers 模块
@@ -12,15 +14,21 @@ type Picker interface {
 }
 
 // Fetcher 定义了从远端获取缓存的能力
-// 所以每个Peer应实现这个接口
+// 所以每个Peer应实现这个接口 ctx的deadline与取消会随请求一并传递给远端
 type Fetcher interface {
-	Fetch(group string, key string) ([]byte, error)
-	Remove(group string, key string) error
+	Fetch(ctx context.Context, group string, key string) ([]byte, error)
+	Remove(ctx context.Context, group string, key string) error
+	Set(ctx context.Context, group string, key string, value []byte, ttl time.Duration) error
+	// FetchMany 一次请求取回多个key 取回失败的key不会出现在结果中
+	// notFound 是远端确认在数据源中不存在的key
+	FetchMany(ctx context.Context, group string, keys []string) (values map[string][]byte, notFound []string, err error)
+	// RemoveMany 一次请求删除多个key
+	RemoveMany(ctx context.Context, group string, keys []string) error
 }
 
 type Cache interface {
 	Get(key string) (value cacheALg.Lengthable, ok bool)
-	Add(key string, value cacheALg.Lengthable, expirationTime int64)
+	Add(key string, value cacheALg.Lengthable, expirationTime time.Time)
 	Remove(key string) (ok bool)
 	Contains(key string) (ok bool)
 }
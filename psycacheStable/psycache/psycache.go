@@ -1,18 +1,37 @@
 package psycache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lruk"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/twoQ"
 	"github.com/Psychopath-H/psycache-master/psycacheStable/singlefilght"
-	"log"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"math/rand"
 	"sync"
+	"time"
 )
 
 const (
-	TYPE_FIFO = "FIFO"
-	TYPE_LRU  = "lru"
-	TYPE_LFU  = "lfu"
-	TYPE_LRUK = "lruk"
-	TYPE_2Q   = "twoQ"
+	defaultHotCacheRatio      = 0                 // 热点缓存默认关闭 通过WithHotCacheRatio开启
+	defaultHotCacheSampleRate = 10                // 默认每10次远端取回的数据中抽取1次放入热点缓存
+	defaultHotCacheTTL        = 10 * time.Second  // 热点副本默认的存活时长
+	hotCacheTTLDivisor        = 10                // 热点副本的存活时长不超过group ttl的1/10
+	defaultLoadTimeout        = defaultRPCTimeout // 每次加载(访问peer或Retriever)默认最多运行的时长
+)
+
+const (
+	TYPE_FIFO     = "FIFO"
+	TYPE_LRU      = "lru"
+	TYPE_LFU      = "lfu"
+	TYPE_LRUK     = "lruk"
+	TYPE_2Q       = "twoQ"
+	TYPE_TINYLFU  = "tinylfu"
+	TYPE_ARC      = "arc"
+	TYPE_CLOCK    = "clock"
+	TYPE_CLOCKPRO = "clockpro"
+	TYPE_S3FIFO   = "s3fifo"
 )
 
 // psycache 模块提供比cache模块更高一层抽象的能力
@@ -24,38 +43,109 @@ var (
 )
 
 // Retriever 要求对象实现从数据源获取数据的能力
+// 返回的ttl小于等于0时 使用group的默认过期时间
 type Retriever interface {
-	retrieve(string) ([]byte, error)
+	retrieve(context.Context, string) ([]byte, time.Duration, error)
 }
 
 type RetrieverFunc func(key string) ([]byte, error)
 
 // RetrieverFunc 通过实现retrieve方法，使得任意匿名函数func
 // 通过被RetrieverFunc(func)类型强制转换后，实现了 Retriever 接口的能力
-func (f RetrieverFunc) retrieve(key string) ([]byte, error) {
-	return f(key)
+func (f RetrieverFunc) retrieve(_ context.Context, key string) ([]byte, time.Duration, error) {
+	bytes, err := f(key)
+	return bytes, 0, err
+}
+
+// RetrieverContextFunc 与 RetrieverFunc 相同 但能拿到调用方的ctx
+// 这样数据源可以感知调用方的deadline与取消
+type RetrieverContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f RetrieverContextFunc) retrieve(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	bytes, err := f(ctx, key)
+	return bytes, 0, err
+}
+
+// RetrieverTTLFunc 在取回数据的同时给出该key的过期时长
+// 这样不同的key可以按数据源的意愿拥有不同的ttl
+type RetrieverTTLFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+func (f RetrieverTTLFunc) retrieve(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
 }
 
 // Group 提供命名管理缓存/填充缓存的能力
 type Group struct {
 	name           string
-	cache          *cache
+	cache          *cache // 本节点负责的key
+	hotCache       *cache // 从远端peer取回的热点key 为nil时不开启
+	negCache       *cache // 数据源中不存在的key 为nil时不开启
 	retriever      Retriever
+	batchRetriever BatchRetriever // 批量取回本节点负责的key 为nil时逐个调用retriever
 	server         Picker
 	flight         *singlefilght.Flight
-	expirationTime int64
+	ttl            time.Duration // 缓存默认的过期时长 从写入时刻算起 0代表永不过期
+	jitter         time.Duration // 在ttl上附加的随机抖动上限 避免大量key同时过期
+	stats          groupStats    // 访问统计
+	logger         Logger
+	tracer         oteltrace.Tracer // 为空时沿用server的tracer
+	writer         Writer           // 将Set/Remove写回数据源 为nil时只操作缓存
+	writeBehind    *writeBehind     // 为nil时同步写回
+
+	writeBehindConfig *WriteBehindConfig // 不为nil时开启write-behind
+	bloom             *bloomGuard        // 为nil时不开启布隆过滤器
+
+	hotCacheRatio      float64       // 热点缓存占maxBytes的比例
+	hotCacheSampleRate int           // 每hotCacheSampleRate次远端取回抽取1次放入热点缓存
+	hotCacheTTL        time.Duration // 热点副本的存活时长
+
+	negativeTTL time.Duration // 负缓存的过期时长 为0时不开启负缓存
+
+	staleWindow  time.Duration // 过期后继续提供旧值的宽限期 为0时不开启stale-while-revalidate
+	refreshAhead time.Duration // 距过期不足refreshAhead时被访问则提前刷新 为0时不开启
+	refreshing   sync.Map      // 正在后台刷新的key
+	xfetchBeta   float64       // 提前过期的激进程度 为0时不开启
+
+	sweepInterval time.Duration // 主动清理过期条目的间隔 为0时只在访问时惰性删除
+	sweepBudget   time.Duration // 每次主动清理最多占用的时间
+
+	twoQOpts []twoQ.Option // 创建TYPE_2Q缓存时的配置
+	lrukOpts []lruk.Option // 创建TYPE_LRUK缓存时的配置
 }
 
-// NewGroup 创建一个新的缓存空间,如果tp不是LRUK的话，最后一个参数k无所谓填什么
-func NewGroup(name string, maxBytes int64, retriever Retriever, tp string, expirationTime int64, k int) *Group {
+// NewGroup 创建一个新的缓存空间,如果tp不是LRUK的话，参数k无所谓填什么
+// ttl是每个缓存从写入时刻起的存活时长 为0时缓存永不过期 tp不是已知的缓存算法时panic
+func NewGroup(name string, maxBytes int64, retriever Retriever, tp string, ttl time.Duration, k int, opts ...GroupOption) *Group {
 	if retriever == nil {
 		panic("Group retriever must be existed!")
 	}
 	g := &Group{
-		name:           name,
-		retriever:      retriever,
-		flight:         &singlefilght.Flight{},
-		expirationTime: expirationTime,
+		name:      name,
+		retriever: retriever,
+		flight:    &singlefilght.Flight{Timeout: defaultLoadTimeout},
+		ttl:       ttl,
+		logger:    nopLogger{},
+
+		hotCacheRatio:      defaultHotCacheRatio,
+		hotCacheSampleRate: defaultHotCacheSampleRate,
+		hotCacheTTL:        defaultHotCacheTTL,
+		negativeTTL:        defaultNegativeTTL,
+	}
+	if batchRetriever, ok := retriever.(BatchRetriever); ok {
+		g.batchRetriever = batchRetriever
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	// 热点缓存从maxBytes中划出 两者之和不超过maxBytes
+	if hotBytes := int64(float64(maxBytes) * g.hotCacheRatio); hotBytes > 0 && hotBytes < maxBytes {
+		g.hotCache = newLRUCache(hotBytes, nil)
+		maxBytes -= hotBytes
+	}
+	// 负缓存同样从maxBytes中划出 只存key 因此占用很小
+	if negBytes := int64(float64(maxBytes) * defaultNegativeCacheRatio); g.negativeTTL > 0 && negBytes > 0 {
+		g.negCache = newLRUCache(negBytes, nil)
+		maxBytes -= negBytes
 	}
 	switch tp {
 	case TYPE_FIFO:
@@ -65,9 +155,30 @@ func NewGroup(name string, maxBytes int64, retriever Retriever, tp string, expir
 	case TYPE_LFU:
 		g.cache = newLFUCache(maxBytes, nil)
 	case TYPE_LRUK:
-		g.cache = newLRUKCache(maxBytes, k, nil)
+		g.cache = newLRUKCache(maxBytes, k, nil, g.lrukOpts...)
 	case TYPE_2Q:
-		g.cache = newtwoQCache(maxBytes, nil)
+		g.cache = newtwoQCache(maxBytes, nil, g.twoQOpts...)
+	case TYPE_TINYLFU:
+		g.cache = newTinyLFUCache(maxBytes, nil)
+	case TYPE_ARC:
+		g.cache = newARCCache(maxBytes, nil)
+	case TYPE_CLOCK:
+		g.cache = newClockCache(maxBytes, nil)
+	case TYPE_CLOCKPRO:
+		g.cache = newClockProCache(maxBytes, nil)
+	case TYPE_S3FIFO:
+		g.cache = newS3FIFOCache(maxBytes, nil)
+	default:
+		panic(fmt.Sprintf("unknown cache type %q", tp))
+	}
+	if g.writer != nil && g.writeBehindConfig != nil {
+		g.writeBehind = newWriteBehind(name, g.writer, *g.writeBehindConfig, g.logger)
+	}
+	if g.bloom != nil {
+		g.startBloomGuard()
+	}
+	for _, c := range g.caches() {
+		c.startSweeper(g.sweepInterval, g.sweepBudget)
 	}
 	mu.Lock()
 	groups[name] = g
@@ -75,12 +186,27 @@ func NewGroup(name string, maxBytes int64, retriever Retriever, tp string, expir
 	return g
 }
 
+// caches 返回 Group 使用的所有缓存
+func (g *Group) caches() []*cache {
+	caches := []*cache{g.cache}
+	if g.hotCache != nil {
+		caches = append(caches, g.hotCache)
+	}
+	if g.negCache != nil {
+		caches = append(caches, g.negCache)
+	}
+	return caches
+}
+
 // RegisterSvr 为 Group 注册 Server
 func (g *Group) RegisterSvr(p Picker) {
 	if g.server != nil {
 		panic("group had been registered server")
 	}
 	g.server = p
+	if s, ok := p.(*server); ok && g.tracer == nil && s.tracerProvider != nil {
+		g.tracer = s.tracer
+	}
 }
 
 // GetGroup 获取对应命名空间的缓存
@@ -91,84 +217,272 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// DestroyGroup 停止 Group 注册的server 并在write-behind队列中的操作全部写回后删除 Group
 func DestroyGroup(name string) {
 	g := GetGroup(name)
 	if g != nil {
-		svr := g.server.(*server)
-		svr.Stop()
+		if svr, ok := g.server.(*server); ok {
+			svr.Stop()
+		}
+		if g.writeBehind != nil {
+			g.writeBehind.close()
+		}
+		if g.bloom != nil {
+			g.bloom.close()
+		}
+		for _, c := range g.caches() {
+			c.stopSweeper()
+		}
+		mu.Lock()
 		delete(groups, name)
-		log.Printf("Destroy cache [%s %s]", name, svr.addr)
+		mu.Unlock()
+		g.logger.Info("destroy group", "group", name)
 	}
 }
 
+// Get 获取key对应的缓存值 等价于 GetContext(context.Background(), key)
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 获取key对应的缓存值 ctx的deadline与取消会传递给远端peer与Retriever
+func (g *Group) GetContext(ctx context.Context, key string) (value ByteView, err error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key required")
 	}
-	if value, ok := g.cache.get(key); ok {
-		log.Println("get cache hit")
+	ctx, span := g.startSpan(ctx, "psycache.Group.Get", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+
+	g.stats.gets.Add(1)
+	if value, ok := g.lookupCache(key); ok {
+		span.SetAttributes(attrHit.Bool(true))
 		return value, nil
 	}
+	if g.lookupNegative(key) {
+		span.SetAttributes(attrHit.Bool(true))
+		return ByteView{}, notFound(key)
+	}
+	span.SetAttributes(attrHit.Bool(false))
+	// 在选择节点之前拒绝一定不存在的key 不会访问远端peer 也不会在peer失败后退回本地查询
+	if g.bloomRejects(key) {
+		return ByteView{}, notFound(key)
+	}
 	// cache missing, get it another way
-	return g.load(key)
+	return g.load(ctx, key)
+}
+
+// lookupCache 依次查找本地缓存与热点缓存
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if e, ok := g.cache.lookup(key); ok {
+		g.logger.Debug("cache hit", "group", g.name, "key", key)
+		g.stats.localHits.Add(1)
+		if g.maybeRefresh(key, e) {
+			g.stats.staleHits.Add(1)
+		}
+		return e.value, true
+	}
+	if g.hotCache != nil {
+		if value, ok := g.hotCache.get(key); ok {
+			g.logger.Debug("hot cache hit", "group", g.name, "key", key)
+			g.stats.localHits.Add(1)
+			g.stats.hotHits.Add(1)
+			return value, true
+		}
+	}
+	return ByteView{}, false
 }
 
-func (g *Group) load(key string) (ByteView, error) {
-	view, err := g.flight.Fly(key, func() (interface{}, error) {
+func (g *Group) load(ctx context.Context, key string) (ByteView, error) {
+	ctx, span := g.startSpan(ctx, "psycache.singleflight", attrKey.String(key))
+	view, err, shared := g.flight.FlyShared(ctx, key, func(ctx context.Context) (interface{}, error) {
 		if g.server != nil {
 			if fetcher, ok := g.server.Pick(key); ok {
-				bytes, err := fetcher.Fetch(g.name, key)
+				start := time.Now()
+				bytes, err := g.fetchFromPeer(ctx, fetcher, key)
+				observeLoad(g.name, sourcePeer, start)
 				if err == nil {
-					return ByteView{b: cloneBytes(bytes)}, nil
+					g.stats.peerLoads.Add(1)
+					value := ByteView{b: cloneBytes(bytes)}
+					g.populateHotCache(key, value)
+					return value, nil
+				}
+				// 远端确认key不存在 本地也负缓存一份 无需再退回本地查询数据源
+				if errors.Is(err, ErrNotFound) {
+					g.stats.peerLoads.Add(1)
+					g.populateNegative(key)
+					return nil, err
 				}
-				log.Printf("fail to get *%s* from peer, %s.\n", key, err.Error())
+				g.stats.peerErrors.Add(1)
+				g.logger.Warn("get from peer failed, load locally", "group", g.name, "key", key, "err", err)
+				return g.getLocally(ctx, key)
 			}
 		}
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
+	span.SetAttributes(attrShared.Bool(shared))
+	endSpan(span, err)
+	if shared {
+		g.stats.dedupedLoads.Add(1)
+		g.logger.Debug("load shared with an in-flight request", "group", g.name, "key", key)
+	}
 	if err == nil {
 		return view.(ByteView), err
 	}
 	return ByteView{}, err
 }
 
+// fetchFromPeer 从负责key的远端节点取回数据
+func (g *Group) fetchFromPeer(ctx context.Context, fetcher Fetcher, key string) ([]byte, error) {
+	ctx, span := g.startSpan(ctx, "psycache.peer.Fetch", attrKey.String(key))
+	if c, ok := fetcher.(*client); ok {
+		span.SetAttributes(attrPeer.String(c.addr))
+	}
+	bytes, err := fetcher.Fetch(ctx, g.name, key)
+	endSpan(span, err)
+	return bytes, err
+}
+
 // getLocally 本地向Retriever取回数据并填充缓存
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.retriever.retrieve(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	start := time.Now()
+	spanCtx, span := g.startSpan(ctx, "psycache.Retriever", attrKey.String(key))
+	bytes, ttl, err := g.retriever.retrieve(spanCtx, key)
+	endSpan(span, err)
+	observeLoad(g.name, sourceRetriever, start)
+	if errors.Is(err, ErrNotFound) {
+		g.stats.retrieverLoads.Add(1)
+		g.populateNegative(key)
+		return ByteView{}, err
+	}
 	if err != nil {
+		g.stats.retrieverErrors.Add(1)
 		return ByteView{}, err
 	}
+	g.stats.retrieverLoads.Add(1)
 	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value, g.expirationTime)
+	g.populateCache(key, value, g.expireAt(ttl), time.Since(start))
 	return value, nil
 }
 
-// populateCache 提供填充缓存的能力
-func (g *Group) populateCache(key string, value ByteView, expirationTime int64) {
-	g.cache.add(key, value, expirationTime)
+// populateHotCache 按采样率将远端取回的数据放入热点缓存
+// 被频繁访问的key更容易被抽中 这样热点key在所有节点上都能本地命中 不会压垮负责它的节点
+// 热点缓存中的副本不会随负责节点上的Set/Remove失效 因此只短暂存活
+// 存活时长取hotCacheTTL与group ttl的1/hotCacheTTLDivisor中较小的一个
+func (g *Group) populateHotCache(key string, value ByteView) {
+	if g.hotCache == nil || rand.Intn(g.hotCacheSampleRate) != 0 {
+		return
+	}
+	ttl := g.hotCacheTTL
+	if g.ttl > 0 {
+		ttl = min(ttl, g.ttl/hotCacheTTLDivisor)
+	}
+	g.hotCache.add(key, value, time.Now().Add(ttl))
+}
+
+// Set 写入key对应的缓存值 等价于 SetContext(context.Background(), key, value, ttl)
+func (g *Group) Set(key string, value []byte, ttl time.Duration) error {
+	return g.SetContext(context.Background(), key, value, ttl)
+}
+
+// SetContext 将缓存值写入负责该key的节点 ttl小于等于0时使用group的默认过期时间
+// 这样写入方可以在更新数据库后主动推送新值 而不必等待缓存过期
+// 配置了 Writer 时先写回数据源 write-through模式下写回失败则不更新缓存
+func (g *Group) SetContext(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key required")
+	}
+	if err := g.writeToSource(ctx, key, value); err != nil {
+		return err
+	}
+	if g.server != nil {
+		if fetcher, ok := g.server.Pick(key); ok {
+			if err := fetcher.Set(ctx, g.name, key, value, ttl); err != nil {
+				return err
+			}
+			// 本地可能残留旧值(例如节点变动前缓存的或热点副本) 一并清理
+			g.cache.remove(key)
+			g.removeHotCache(key)
+			g.removeNegative(key)
+			g.bloomAdd(key)
+			return nil
+		}
+	}
+	g.setLocally(key, value, ttl)
+	return nil
+}
+
+// setLocally 将缓存值写入本地缓存
+func (g *Group) setLocally(key string, value []byte, ttl time.Duration) {
+	g.removeNegative(key)
+	g.bloomAdd(key)
+	g.populateCache(key, ByteView{b: cloneBytes(value)}, g.expireAt(ttl), 0)
 }
 
-// Remove 删除缓存中的数据
+// expireAt 计算从此刻写入的缓存的过期时刻 ttl小于等于0时使用group的默认ttl
+// 返回零值代表永不过期
+func (g *Group) expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = g.ttl
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	if g.jitter > 0 {
+		ttl += time.Duration(rand.Int63n(int64(g.jitter)))
+	}
+	return time.Now().Add(ttl)
+}
+
+// populateCache 提供填充缓存的能力 delta是加载该值的耗时 供提前过期使用
+// 开启stale-while-revalidate时 值在expirationTime之后仍会保留staleWindow
+func (g *Group) populateCache(key string, value ByteView, expirationTime time.Time, delta time.Duration) {
+	g.cache.put(key, entry{value: value, expire: expirationTime, delta: delta}, g.retainUntil(expirationTime))
+}
+
+// Remove 删除缓存中的数据 等价于 RemoveContext(context.Background(), key)
 func (g *Group) Remove(key string) error {
+	return g.RemoveContext(context.Background(), key)
+}
+
+// RemoveContext 删除缓存中的数据 ctx会传递给远端peer
+// 配置了 Writer 时先从数据源删除 write-through模式下删除失败则保留缓存
+func (g *Group) RemoveContext(ctx context.Context, key string) error {
 	if key == "" {
 		return fmt.Errorf("key required")
 	}
+	if err := g.deleteFromSource(ctx, key); err != nil {
+		return err
+	}
+	return g.removeCache(ctx, key)
+}
+
+// removeCache 删除本地与负责该key的节点上的缓存 不经过 Writer
+func (g *Group) removeCache(ctx context.Context, key string) error {
+	// 热点副本只是远端数据的拷贝 删除后仍需通知负责该key的节点
+	g.removeHotCache(key)
 	if ok := g.cache.remove(key); ok {
-		log.Println("remove cache hit")
+		g.logger.Debug("remove cache hit", "group", g.name, "key", key)
 		return nil
 	}
 	// remove local cache missing, get it another way
-	return g.loadremove(key)
+	return g.loadremove(ctx, key)
+}
+
+// removeHotCache 删除本地的热点副本
+func (g *Group) removeHotCache(key string) {
+	if g.hotCache != nil {
+		g.hotCache.remove(key)
+	}
 }
 
 // loadremove 删除远端节点的缓存
-func (g *Group) loadremove(key string) error {
-	_, err := g.flight.Fly(key, func() (interface{}, error) {
+func (g *Group) loadremove(ctx context.Context, key string) error {
+	_, err := g.flight.Fly(ctx, key, func(ctx context.Context) (interface{}, error) {
 		if g.server != nil {
 			if fetcher, ok := g.server.Pick(key); ok {
-				err := fetcher.Remove(g.name, key)
+				err := fetcher.Remove(ctx, g.name, key)
 				if err != nil {
-					log.Printf("fail to remove *%s* from peer, %s.\n", key, err.Error())
+					g.logger.Warn("remove from peer failed", "group", g.name, "key", key, "err", err)
 				}
 				return nil, nil
 			}
@@ -1,10 +1,22 @@
 package psycache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/lruk"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm/twoQ"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"log"
+	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGet(t *testing.T) {
@@ -26,7 +38,7 @@ func TestGet(t *testing.T) {
 				return []byte(v), nil
 			}
 			return nil, fmt.Errorf("%s not exist", key)
-		}), TYPE_LFU, initTime(), 2)
+		}), TYPE_LFU, defaultTTL, 2)
 
 	for k, v := range mysql {
 		if view, err := g.Get(k); err != nil || view.String() != v {
@@ -44,6 +56,85 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetContext(t *testing.T) {
+	type ctxKey struct{}
+	g := NewGroup("scores-ctx", 2<<10, RetrieverContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				return nil, fmt.Errorf("deadline of %s lost", key)
+			}
+			if v, ok := ctx.Value(ctxKey{}).(string); ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}), TYPE_LRU, defaultTTL, 2)
+
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), ctxKey{}, "630"), time.Second)
+	defer cancel()
+	if view, err := g.GetContext(ctx, "Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("failed to get value of Tom, %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.GetContext(cancelled, "Jack"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect context.Canceled but %v got", err)
+	}
+
+	// 调用方没有deadline时 加载仍受group的加载超时限制
+	g = NewGroup("scores-loadtimeout", 2<<10, RetrieverContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}), TYPE_LRU, defaultTTL, 2, WithLoadTimeout(20*time.Millisecond))
+	if _, err := g.Get("Tom"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect context.DeadlineExceeded but %v got", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	loadCounts := 0
+	g := NewGroup("scores-set", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			loadCounts++
+			return []byte("630"), nil
+		}), TYPE_LRU, defaultTTL, 2)
+
+	if err := g.Set("Tom", []byte("700"), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := g.Get("Tom"); err != nil || view.String() != "700" || loadCounts != 0 {
+		t.Fatalf("expect the value set before, but %s got", view)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if view, err := g.Get("Tom"); err != nil || view.String() != "630" || loadCounts != 1 {
+		t.Fatalf("Tom should be expired and reloaded, but %s got", view)
+	}
+	if err := g.Set("", []byte("700"), 0); err == nil {
+		t.Fatal("empty key should be rejected")
+	}
+}
+
+func TestRetrieverTTL(t *testing.T) {
+	g := NewGroup("scores-ttl", 2<<10, RetrieverTTLFunc(
+		func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			if key == "Tom" {
+				return []byte("630"), 100 * time.Millisecond, nil
+			}
+			return []byte("589"), 0, nil
+		}), TYPE_LRU, defaultTTL, 2, WithTTLJitter(10*time.Millisecond))
+
+	g.Get("Tom")
+	g.Get("Jack")
+	time.Sleep(200 * time.Millisecond)
+	if g.cache.contains("Tom") {
+		t.Fatal("Tom should be expired with its own ttl")
+	}
+	if !g.cache.contains("Jack") {
+		t.Fatal("Jack should live with the default ttl")
+	}
+}
+
 func TestAll(t *testing.T) {
 	// 模拟MySQL数据库 用于peanutcache从数据源获取值
 	var mysql = map[string]string{
@@ -59,7 +150,7 @@ func TestAll(t *testing.T) {
 				return []byte(v), nil
 			}
 			return nil, fmt.Errorf("%s not exist", key)
-		}), TYPE_LFU, initTime(), 2)
+		}), TYPE_LFU, defaultTTL, 2)
 	// New一个服务实例
 	var addr string = "localhost:9999"
 	svr, err := NewServer(addr)
@@ -100,3 +191,708 @@ func GetTomScore(group *Group, wg *sync.WaitGroup) {
 	}
 	fmt.Println(view.String())
 }
+
+// fakePeer 模拟负责除locals以外所有key的远端节点
+type fakePeer struct {
+	mu      sync.Mutex
+	locals  map[string]bool
+	missing map[string]bool // 远端返回 ErrNotFound 的key
+	fetches int             // RPC次数
+	removes int
+}
+
+func (p *fakePeer) Pick(key string) (Fetcher, bool) { return p, !p.locals[key] }
+
+func (p *fakePeer) Fetch(_ context.Context, _ string, key string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetches++
+	if p.missing[key] {
+		return nil, notFound(key)
+	}
+	return []byte("remote-" + key), nil
+}
+
+func (p *fakePeer) Remove(context.Context, string, string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removes++
+	return nil
+}
+
+func (p *fakePeer) Set(context.Context, string, string, []byte, time.Duration) error { return nil }
+
+func (p *fakePeer) FetchMany(_ context.Context, _ string, keys []string) (map[string][]byte, []string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetches++
+	values := make(map[string][]byte, len(keys))
+	var notFound []string
+	for _, key := range keys {
+		switch {
+		case p.missing[key]:
+			notFound = append(notFound, key)
+		case key != "unknown":
+			values[key] = []byte("remote-" + key)
+		}
+	}
+	return values, notFound, nil
+}
+
+func (p *fakePeer) RemoveMany(context.Context, string, []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removes++
+	return nil
+}
+
+func TestHotCache(t *testing.T) {
+	g := NewGroup("scores-hot", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be fetched from peer", key)
+		}), TYPE_LRU, defaultTTL, 2, WithHotCacheRatio(0.125), WithHotCacheSampleRate(1))
+	peer := &fakePeer{}
+	g.RegisterSvr(peer)
+
+	for i := 0; i < 3; i++ {
+		if view, err := g.Get("Tom"); err != nil || view.String() != "remote-Tom" {
+			t.Fatalf("failed to get value of Tom, %v", err)
+		}
+	}
+	if peer.fetches != 1 {
+		t.Fatalf("hot key should be served locally, but fetched %d times", peer.fetches)
+	}
+	if g.cache.contains("Tom") {
+		t.Fatal("remote value should not be populated into main cache")
+	}
+
+	// 删除时清理热点副本 并通知负责该key的节点
+	if err := g.Remove("Tom"); err != nil || peer.removes != 1 {
+		t.Fatalf("remove should reach the owner, %v", err)
+	}
+	if _, err := g.Get("Tom"); err != nil || peer.fetches != 2 {
+		t.Fatal("hot copy should be removed")
+	}
+	if err := g.Set("Tom", []byte("630"), 0); err != nil || g.hotCache.contains("Tom") {
+		t.Fatal("hot copy should be removed after set")
+	}
+
+	// 热点副本的存活时长不超过group ttl的1/10
+	g = NewGroup("scores-hot-ttl", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) { return nil, nil }),
+		TYPE_LRU, 200*time.Millisecond, 2, WithHotCacheRatio(0.125), WithHotCacheSampleRate(1), WithHotCacheTTL(time.Hour))
+	peer = &fakePeer{}
+	g.RegisterSvr(peer)
+	g.Get("Tom")
+	g.Get("Tom")
+	time.Sleep(30 * time.Millisecond)
+	if g.Get("Tom"); peer.fetches != 2 {
+		t.Fatalf("hot copy should expire after ttl/10, fetched %d times", peer.fetches)
+	}
+
+	// 默认关闭热点缓存
+	g = NewGroup("scores-nohot", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) { return nil, nil }), TYPE_LRU, defaultTTL, 2)
+	if g.hotCache != nil {
+		t.Fatal("hot cache should be disabled by default")
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	mysql := map[string]string{
+		"Tom":  "630",
+		"Jack": "589",
+	}
+	var batches [][]string
+	g := NewGroup("scores-many", 2<<10, BatchRetrieverFunc(
+		func(ctx context.Context, keys []string) (map[string][]byte, error) {
+			batches = append(batches, keys)
+			values := make(map[string][]byte)
+			for _, key := range keys {
+				if v, ok := mysql[key]; ok {
+					values[key] = []byte(v)
+				}
+			}
+			return values, nil
+		}), TYPE_LRU, defaultTTL, 2, WithHotCacheRatio(0))
+	peer := &fakePeer{locals: map[string]bool{"Tom": true, "Jack": true, "Lily": true}}
+	g.RegisterSvr(peer)
+
+	keys := []string{"Tom", "Jack", "Lily", "Sam", "Amy", "unknown", "Tom"}
+	values, err := g.GetMany(context.Background(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"Tom": "630", "Jack": "589", "Sam": "remote-Sam", "Amy": "remote-Amy"}
+	if len(values) != len(expect) {
+		t.Fatalf("Actual: %d\tExpect: %d values", len(values), len(expect))
+	}
+	for key, v := range expect {
+		if values[key].String() != v {
+			t.Fatalf("Actual: %s\tExpect: %s", values[key].String(), v)
+		}
+	}
+	// 远端节点只请求一次 本地未命中的key一次性交给BatchRetriever
+	if peer.fetches != 1 || len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expect 1 RPC and 1 batch of 3 keys, but %d RPC and batches %v", peer.fetches, batches)
+	}
+	if _, err := g.GetMany(context.Background(), []string{"Tom", "Jack"}); err != nil || len(batches) != 1 {
+		t.Fatal("cached keys should not be retrieved again")
+	}
+
+	if err := g.RemoveMany(context.Background(), []string{"Tom", "Sam", "Amy"}); err != nil {
+		t.Fatal(err)
+	}
+	if peer.removes != 1 || g.cache.contains("Tom") {
+		t.Fatal("remote keys should be removed in one RPC and local keys removed locally")
+	}
+	if _, err := g.GetMany(context.Background(), []string{"Tom", ""}); err == nil {
+		t.Fatal("empty key should be rejected")
+	}
+}
+
+func TestStats(t *testing.T) {
+	g := NewGroup("scores-stats", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			if key == "Tom" {
+				return []byte("630"), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}), TYPE_LRU, defaultTTL, 2, WithHotCacheRatio(0.125), WithHotCacheSampleRate(1))
+	peer := &fakePeer{locals: map[string]bool{"Tom": true, "unknown": true}}
+	g.RegisterSvr(peer)
+
+	g.Get("Tom")     // retriever load
+	g.Get("Tom")     // local hit
+	g.Get("unknown") // retriever error
+	g.Get("Sam")     // peer load
+	g.Get("Sam")     // hot cache hit
+
+	stats := g.Stats()
+	expect := Stats{Gets: 5, LocalHits: 2, HotHits: 1, PeerLoads: 1, RetrieverLoads: 1, RetrieverErrors: 1}
+	expect.MainCache, expect.HotCache = stats.MainCache, stats.HotCache
+	if stats != expect {
+		t.Fatalf("Actual: %+v\tExpect: %+v", stats, expect)
+	}
+	if stats.MainCache.Entries != 1 || stats.MainCache.Bytes != int64(len("Tom")+len("630")) || stats.HotCache.Entries != 1 {
+		t.Fatalf("unexpected cache stats %+v %+v", stats.MainCache, stats.HotCache)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	g := NewGroup("scores-logger", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}), TYPE_LRU, defaultTTL, 2, WithGroupLogger(logger))
+	g.Get("Tom")
+	g.Get("Tom")
+	if !strings.Contains(buf.String(), `msg="cache hit" group=scores-logger key=Tom`) {
+		t.Fatalf("cache hit should be logged at debug level, got %q", buf.String())
+	}
+
+	// 默认丢弃所有日志
+	g = NewGroup("scores-nologger", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}), TYPE_LRU, defaultTTL, 2)
+	if _, ok := g.logger.(nopLogger); !ok {
+		t.Fatalf("Actual: %T\tExpect: nopLogger", g.logger)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	g := NewGroup("scores-tracing", 2<<10, RetrieverContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if !oteltrace.SpanFromContext(ctx).SpanContext().IsValid() {
+				return nil, fmt.Errorf("span of %s lost", key)
+			}
+			return []byte("630"), nil
+		}), TYPE_LRU, defaultTTL, 2, WithGroupTracerProvider(tp))
+	if _, err := g.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	get, flight, retriever := byName["psycache.Group.Get"], byName["psycache.singleflight"], byName["psycache.Retriever"]
+	if len(spans) != 3 || !get.SpanContext.IsValid() || !flight.SpanContext.IsValid() || !retriever.SpanContext.IsValid() {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+	if flight.Parent.SpanID() != get.SpanContext.SpanID() || retriever.Parent.SpanID() != flight.SpanContext.SpanID() {
+		t.Fatal("spans should be nested as Get -> singleflight -> Retriever")
+	}
+
+	// 命中时只有Get的span
+	exporter.Reset()
+	g.Get("Tom")
+	if spans := exporter.GetSpans(); len(spans) != 1 || spans[0].Name != "psycache.Group.Get" {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+}
+
+// fakeWriter 记录写回数据源的操作 failures大于0时前failures次写回失败 hang为true时阻塞直至ctx结束
+type fakeWriter struct {
+	mu       sync.Mutex
+	db       map[string]string
+	batches  [][]WriteOp
+	failures int
+	hang     bool
+}
+
+func (w *fakeWriter) Write(ctx context.Context, key string, value []byte) error {
+	return w.WriteBatch(ctx, []WriteOp{{Key: key, Value: value}})
+}
+
+func (w *fakeWriter) Delete(ctx context.Context, key string) error {
+	return w.WriteBatch(ctx, []WriteOp{{Key: key, Delete: true}})
+}
+
+func (w *fakeWriter) WriteBatch(ctx context.Context, ops []WriteOp) error {
+	if w.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("db unavailable")
+	}
+	w.batches = append(w.batches, ops)
+	for _, op := range ops {
+		if op.Delete {
+			delete(w.db, op.Key)
+		} else {
+			w.db[op.Key] = string(op.Value)
+		}
+	}
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	retriever := RetrieverFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	})
+
+	// write-through 写回失败时缓存保持不变
+	w := &fakeWriter{db: map[string]string{}, failures: 1}
+	g := NewGroup("scores-write-through", 2<<10, retriever, TYPE_LRU, defaultTTL, 2, WithWriteThrough(w))
+	if err := g.Set("Tom", []byte("630"), 0); err == nil {
+		t.Fatal("set should fail when writer fails")
+	}
+	if _, err := g.Get("Tom"); err == nil {
+		t.Fatal("failed write should not populate cache")
+	}
+	if err := g.Set("Tom", []byte("630"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := g.Get("Tom"); err != nil || view.String() != "630" || w.db["Tom"] != "630" {
+		t.Fatalf("Actual: %v %v %v\tExpect: 630", view, err, w.db)
+	}
+	if err := g.Remove("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.db["Tom"]; ok {
+		t.Fatal("remove should delete Tom from db")
+	}
+
+	// write-behind 攒批 合并同一key的操作 失败后重试 销毁时写完队列
+	w = &fakeWriter{db: map[string]string{}, failures: 1}
+	g = NewGroup("scores-write-behind", 2<<10, retriever, TYPE_LRU, defaultTTL, 2,
+		WithWriteBehind(w, WriteBehindConfig{BatchSize: 100, FlushInterval: time.Hour, RetryBackoff: time.Millisecond}))
+	g.Set("Tom", []byte("1"), 0)
+	g.Set("Tom", []byte("630"), 0)
+	g.Set("Jack", []byte("589"), 0)
+	if view, err := g.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("write behind should update cache immediately, got %v %v", view, err)
+	}
+	if err := g.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.batches) != 1 || len(w.batches[0]) != 2 || w.db["Tom"] != "630" || w.db["Jack"] != "589" {
+		t.Fatalf("unexpected batches %v", w.batches)
+	}
+
+	g.RemoveMany(context.Background(), []string{"Tom", "Jack"})
+	g.Set("Sam", []byte("567"), 0)
+	DestroyGroup(g.name)
+	if len(w.db) != 1 || w.db["Sam"] != "567" {
+		t.Fatalf("DestroyGroup should flush pending writes, got %v", w.db)
+	}
+	if err := g.Set("Sam", []byte("0"), 0); !errors.Is(err, ErrWriterClosed) {
+		t.Fatalf("Actual: %v\tExpect: %v", err, ErrWriterClosed)
+	}
+
+	// Writer卡住时每次写回在WriteTimeout后放弃 DestroyGroup不会一直阻塞
+	w = &fakeWriter{db: map[string]string{}, hang: true}
+	g = NewGroup("scores-write-behind-hang", 2<<10, retriever, TYPE_LRU, defaultTTL, 2,
+		WithWriteBehind(w, WriteBehindConfig{FlushInterval: time.Hour, MaxRetries: 1, RetryBackoff: time.Millisecond,
+			WriteTimeout: 10 * time.Millisecond}))
+	g.Set("Tom", []byte("630"), 0)
+	destroyed := make(chan struct{})
+	go func() {
+		DestroyGroup(g.name)
+		close(destroyed)
+	}()
+	select {
+	case <-destroyed:
+	case <-time.After(time.Second):
+		t.Fatal("DestroyGroup should not block on a hung writer")
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	var retrieves int
+	mysql := map[string]string{"Tom": "630"}
+	g := NewGroup("scores-negative", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			retrieves++
+			if v, ok := mysql[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}), TYPE_LRU, defaultTTL, 2, WithNegativeTTL(50*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get("Unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Actual: %v\tExpect: %v", err, ErrNotFound)
+		}
+	}
+	if retrieves != 1 || g.Stats().NegativeHits != 2 {
+		t.Fatalf("missing key should be retrieved once, got %d retrieves %+v", retrieves, g.Stats())
+	}
+	// 负缓存过期后重新访问数据源
+	time.Sleep(60 * time.Millisecond)
+	g.Get("Unknown")
+	if retrieves != 2 {
+		t.Fatalf("Actual: %d\tExpect: 2", retrieves)
+	}
+	// 写入后负缓存失效
+	g.Set("Unknown", []byte("0"), 0)
+	if view, err := g.Get("Unknown"); err != nil || view.String() != "0" {
+		t.Fatalf("Actual: %v %v\tExpect: 0", view, err)
+	}
+
+	// 远端返回 ErrNotFound 时不退回本地查询数据源
+	peer := &fakePeer{locals: map[string]bool{}, missing: map[string]bool{"Jack": true}}
+	g.server = peer
+	if _, err := g.Get("Jack"); !errors.Is(err, ErrNotFound) || retrieves != 2 {
+		t.Fatalf("Actual: %v %d\tExpect: %v 2", err, retrieves, ErrNotFound)
+	}
+	// 批量获取时远端确认不存在的key同样在本地负缓存
+	peer.missing["Lily"] = true
+	if values, notFound, err := g.getMany(context.Background(), []string{"Lily", "Sam"}); err != nil || len(values) != 1 ||
+		len(notFound) != 1 || notFound[0] != "Lily" {
+		t.Fatalf("Actual: %v %v %v\tExpect: 1 value and Lily not found", values, notFound, err)
+	}
+	hits := g.Stats().NegativeHits
+	if _, err := g.Get("Lily"); !errors.Is(err, ErrNotFound) || peer.fetches != 2 || g.Stats().NegativeHits != hits+1 {
+		t.Fatalf("Actual: %v %d fetches\tExpect: negative hit without fetching", err, peer.fetches)
+	}
+
+	// 负缓存默认关闭 不占用主缓存的容量 每次都访问数据源
+	g = NewGroup("scores-nonegative", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			retrieves++
+			return nil, ErrNotFound
+		}), TYPE_LRU, defaultTTL, 2)
+	if g.negCache != nil {
+		t.Fatal("negative cache should be disabled by default")
+	}
+	retrieves = 0
+	g.Get("Unknown")
+	g.Get("Unknown")
+	if retrieves != 2 {
+		t.Fatalf("Actual: %d\tExpect: 2", retrieves)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	var retrieves atomic.Int64
+	mysql := map[string]string{"Tom": "630", "Jack": "589"}
+	retriever := RetrieverFunc(func(key string) ([]byte, error) {
+		retrieves.Add(1)
+		if v, ok := mysql[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, ErrNotFound
+	})
+	enumerate := func(ctx context.Context, add func(key string)) error {
+		for key := range mysql {
+			add(key)
+		}
+		return nil
+	}
+	g := NewGroup("scores-bloom", 2<<10, retriever, TYPE_LRU, defaultTTL, 2,
+		WithNegativeTTL(0), WithBloomFilter(BloomConfig{Enumerate: enumerate, FalsePositiveRate: 0.001}))
+	peer := &fakePeer{locals: map[string]bool{"Tom": true, "Jack": true, "Sam": true, "Lily": true}}
+	g.server = peer
+	for i := 0; i < 10; i++ {
+		peer.locals[fmt.Sprintf("Unknown-%d", i)] = true
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := g.Get(fmt.Sprintf("Unknown-%d", i)); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Actual: %v\tExpect: %v", err, ErrNotFound)
+		}
+	}
+	if view, err := g.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("Actual: %v %v\tExpect: 630", view, err)
+	}
+	if retrieves.Load() != 1 || peer.fetches != 0 || g.Stats().BloomRejects != 10 {
+		t.Fatalf("missing keys should be rejected before retriever and peers, got %d retrieves %d fetches %+v",
+			retrieves.Load(), peer.fetches, g.Stats())
+	}
+
+	// 由其他节点负责的key同样在选择节点之前被拒绝 不发送RPC
+	if _, err := g.Get("Remote"); !errors.Is(err, ErrNotFound) || peer.fetches != 0 {
+		t.Fatalf("Actual: %v %d fetches\tExpect: %v 0 fetches", err, peer.fetches, ErrNotFound)
+	}
+	if values, _ := g.GetMany(context.Background(), []string{"Remote", "Unknown-0", "Tom"}); len(values) != 1 ||
+		peer.fetches != 0 || g.Stats().BloomRejects != 13 {
+		t.Fatalf("missing keys should be rejected before grouping by peer, got %v %d fetches %+v", values, peer.fetches, g.Stats())
+	}
+	// 经由其他节点Set写入的key 在数据源更新且本节点重建过滤器后才能访问
+	mysql["Remote"] = "600"
+	if err := g.RebuildBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := g.Get("Remote"); err != nil || view.String() != "remote-Remote" || peer.fetches != 1 {
+		t.Fatalf("Actual: %v %v\tExpect: remote-Remote", view, err)
+	}
+
+	// Set写入的key立刻通过过滤器
+	g.Set("Sam", []byte("567"), 0)
+	g.cache.remove("Sam")
+	mysql["Sam"] = "567"
+	if view, err := g.Get("Sam"); err != nil || view.String() != "567" {
+		t.Fatalf("Actual: %v %v\tExpect: 567", view, err)
+	}
+
+	// 数据源新增的key在重建后才能访问
+	mysql["Lily"] = "610"
+	if _, err := g.Get("Lily"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Actual: %v\tExpect: %v", err, ErrNotFound)
+	}
+	if err := g.RebuildBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := g.Get("Lily"); err != nil || view.String() != "610" {
+		t.Fatalf("Actual: %v %v\tExpect: 610", view, err)
+	}
+
+	// 未配置时所有key都会访问数据源
+	g = NewGroup("scores-nobloom", 2<<10, retriever, TYPE_LRU, defaultTTL, 2)
+	if err := g.RebuildBloomFilter(context.Background()); !errors.Is(err, ErrBloomFilterDisabled) {
+		t.Fatalf("Actual: %v\tExpect: %v", err, ErrBloomFilterDisabled)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var retrieves atomic.Int64
+	retriever := RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(fmt.Sprintf("v%d", retrieves.Add(1))), nil
+	})
+	waitRetrieves := func(n int64) {
+		for deadline := time.Now().Add(time.Second); retrieves.Load() < n && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		// 等待刷新的结果写入缓存
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	g := NewGroup("scores-swr", 2<<10, retriever, TYPE_LRU, 50*time.Millisecond, 2,
+		WithStaleWhileRevalidate(time.Second))
+	g.Get("Tom")
+	time.Sleep(60 * time.Millisecond)
+	// 过期后仍返回旧值 并在后台刷新
+	if view, err := g.Get("Tom"); err != nil || view.String() != "v1" {
+		t.Fatalf("Actual: %v %v\tExpect: v1", view, err)
+	}
+	waitRetrieves(2)
+	if view, err := g.Get("Tom"); err != nil || view.String() != "v2" {
+		t.Fatalf("Actual: %v %v\tExpect: v2", view, err)
+	}
+	if stats := g.Stats(); stats.StaleHits != 1 || stats.Refreshes != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 超过宽限期后阻塞加载
+	retrieves.Store(0)
+	g = NewGroup("scores-swr-expired", 2<<10, retriever, TYPE_LRU, 20*time.Millisecond, 2,
+		WithStaleWhileRevalidate(20*time.Millisecond))
+	g.Get("Tom")
+	time.Sleep(50 * time.Millisecond)
+	if view, err := g.Get("Tom"); err != nil || view.String() != "v2" {
+		t.Fatalf("Actual: %v %v\tExpect: v2", view, err)
+	}
+
+	// 即将过期时被访问 提前刷新
+	retrieves.Store(0)
+	g = NewGroup("scores-refresh-ahead", 2<<10, retriever, TYPE_LRU, 100*time.Millisecond, 2,
+		WithRefreshAhead(80*time.Millisecond))
+	g.Get("Tom")
+	time.Sleep(30 * time.Millisecond)
+	if view, err := g.Get("Tom"); err != nil || view.String() != "v1" {
+		t.Fatalf("Actual: %v %v\tExpect: v1", view, err)
+	}
+	waitRetrieves(2)
+	if view, err := g.Get("Tom"); err != nil || view.String() != "v2" {
+		t.Fatalf("Actual: %v %v\tExpect: v2", view, err)
+	}
+
+	// Retriever卡住时刷新在加载超时后放弃 不会一直占着该key的航班
+	var hung atomic.Bool
+	g = NewGroup("scores-refresh-timeout", 2<<10, RetrieverContextFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if hung.Load() {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return []byte("v1"), nil
+		}), TYPE_LRU, 20*time.Millisecond, 2, WithStaleWhileRevalidate(time.Second), WithLoadTimeout(30*time.Millisecond))
+	g.Get("Tom")
+	hung.Store(true)
+	time.Sleep(30 * time.Millisecond)
+	if view, err := g.Get("Tom"); err != nil || view.String() != "v1" {
+		t.Fatalf("Actual: %v %v\tExpect: v1", view, err)
+	}
+	refreshing := func() bool {
+		_, ok := g.refreshing.Load("Tom")
+		return ok
+	}
+	for deadline := time.Now().Add(time.Second); refreshing() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if refreshing() {
+		t.Fatal("hung refresh should give up after the load timeout")
+	}
+}
+
+func TestEarlyExpiration(t *testing.T) {
+	var retrieves atomic.Int64
+	retriever := RetrieverFunc(func(key string) ([]byte, error) {
+		time.Sleep(10 * time.Millisecond)
+		return []byte(fmt.Sprintf("v%d", retrieves.Add(1))), nil
+	})
+	// beta很大时 加载耗时10ms的值几乎在写入后就会被提前刷新
+	g := NewGroup("scores-xfetch", 2<<10, retriever, TYPE_LRU, time.Second, 2, WithEarlyExpiration(1e6))
+	g.Get("Tom")
+	if view, err := g.Get("Tom"); err != nil || view.String() != "v1" {
+		t.Fatalf("early refresh should not block the caller, got %v %v", view, err)
+	}
+	for deadline := time.Now().Add(time.Second); retrieves.Load() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := g.Stats(); retrieves.Load() != 2 || stats.EarlyRefreshes != 1 {
+		t.Fatalf("Actual: %d retrieves %+v\tExpect: 2 retrieves 1 early refresh", retrieves.Load(), stats)
+	}
+
+	// 未开启时不会提前刷新
+	retrieves.Store(0)
+	g = NewGroup("scores-noxfetch", 2<<10, retriever, TYPE_LRU, time.Second, 2)
+	for i := 0; i < 10; i++ {
+		g.Get("Tom")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if retrieves.Load() != 1 || g.Stats().EarlyRefreshes != 0 {
+		t.Fatalf("Actual: %d\tExpect: 1", retrieves.Load())
+	}
+}
+
+func TestExpirationSweeper(t *testing.T) {
+	g := NewGroup("scores-sweeper", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}), TYPE_LRU, 10*time.Millisecond, 2, WithExpirationSweeper(5*time.Millisecond, 0))
+	for i := 0; i < 50; i++ {
+		g.Set(fmt.Sprintf("key%d", i), []byte("v"), 0)
+	}
+	// 不访问任何key 过期的缓存也会被主动清理
+	for deadline := time.Now().Add(time.Second); g.Stats().MainCache.Entries > 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := g.Stats().MainCache; stats.Entries != 0 || stats.Expirations != 50 || stats.Bytes != 0 {
+		t.Fatalf("expired entries should be swept, got %+v", stats)
+	}
+	done := g.cache.sweepDone
+	DestroyGroup(g.name)
+	select {
+	case <-done:
+	default:
+		t.Fatal("DestroyGroup should wait for the sweeper to exit")
+	}
+	if g.cache.stopSweep != nil {
+		t.Fatal("DestroyGroup should stop the sweeper")
+	}
+}
+
+func TestNewGroupUnknownType(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("unknown cache type should panic")
+		}
+		if GetGroup("scores-unknown") != nil {
+			t.Fatal("group of unknown cache type should not be registered")
+		}
+	}()
+	NewGroup("scores-unknown", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) { return nil, nil }), "LRU", defaultTTL, 2, WithExpirationSweeper(time.Millisecond, 0))
+}
+
+// 自身并发安全的缓存算法读取时不加mu 多个goroutine并发读取 配合-race检查数据竞争
+func TestConcurrentPolicies(t *testing.T) {
+	for _, typ := range []string{TYPE_CLOCK, TYPE_CLOCKPRO, TYPE_S3FIFO} {
+		g := NewGroup("scores-"+typ, 2<<10, RetrieverFunc(
+			func(key string) ([]byte, error) {
+				return []byte("v-" + key), nil
+			}), typ, defaultTTL, 2)
+		if !g.cache.concurrent {
+			t.Fatalf("%s should skip the cache mutex on lookup", typ)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					key := fmt.Sprintf("key%d", j%10)
+					if view, err := g.Get(key); err != nil || view.String() != "v-"+key {
+						t.Errorf("%s: failed to get value of %s", typ, key)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		DestroyGroup(g.name)
+	}
+}
+
+// 缓存算法的配置经由GroupOption传入 只对对应的算法生效
+func TestCachePolicyOptions(t *testing.T) {
+	retriever := RetrieverFunc(func(key string) ([]byte, error) { return []byte("630"), nil })
+	g := NewGroup("scores-twoQ", 2<<10, retriever, TYPE_2Q, defaultTTL, 2, WithTwoQKin(0.5), WithTwoQKout(1))
+	if _, ok := g.cache.specificCache.(*twoQ.TwoQCache); !ok || len(g.twoQOpts) != 2 {
+		t.Fatalf("twoQ options should be passed to the cache, got %d", len(g.twoQOpts))
+	}
+	if view, err := g.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("failed to get value of Tom, %v", err)
+	}
+	DestroyGroup(g.name)
+
+	g = NewGroup("scores-lruk", 2<<10, retriever, TYPE_LRUK, defaultTTL, 2,
+		WithLRUKCorrelatedReferencePeriod(time.Second), WithLRUKHistoryRatio(2))
+	if _, ok := g.cache.specificCache.(*lruk.LRUKCache); !ok || len(g.lrukOpts) != 2 {
+		t.Fatalf("lruk options should be passed to the cache, got %d", len(g.lrukOpts))
+	}
+	if view, err := g.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("failed to get value of Tom, %v", err)
+	}
+	DestroyGroup(g.name)
+}
@@ -0,0 +1,85 @@
+package psycache
+
+// refresh 模块让缓存过期时不阻塞调用方
+// stale-while-revalidate: 值过期后在宽限期内仍直接返回旧值 同时在后台经由singleflight重新加载一次
+// refresh-ahead: 值即将过期时被访问 提前在后台重新加载 这样热点key的访问延迟不会因过期而抖动
+// XFetch: 每次访问以随过期临近而增大的概率提前刷新 加载越慢的值越早开始刷新
+// 同时写入的key在各节点上的刷新时刻因此被随机打散 不会在过期时刻集中回源
+//
+// XFetch的判定条件为 now - delta*beta*ln(rand) >= expire
+// 其中delta是上次加载的耗时 rand在(0,1]上均匀分布 见Vattani等人的Optimal Probabilistic Cache Stampede Prevention
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// retainUntil 计算缓存算法中的过期时刻 开启stale-while-revalidate时比逻辑过期时刻晚staleWindow
+func (g *Group) retainUntil(expire time.Time) time.Time {
+	if expire.IsZero() || g.staleWindow <= 0 {
+		return expire
+	}
+	return expire.Add(g.staleWindow)
+}
+
+// maybeRefresh 在值已过期或即将过期时触发后台刷新 返回值是否已过期
+func (g *Group) maybeRefresh(key string, e entry) bool {
+	if e.expire.IsZero() {
+		return false
+	}
+	now := time.Now()
+	if e.stale(now) {
+		g.refreshAsync(key)
+		return true
+	}
+	if g.refreshAhead > 0 && e.expire.Sub(now) <= g.refreshAhead {
+		g.refreshAsync(key)
+	} else if g.xfetch(e, now) && g.refreshAsync(key) {
+		g.stats.earlyRefreshes.Add(1)
+	}
+	return false
+}
+
+// xfetch 判断是否提前刷新e
+func (g *Group) xfetch(e entry, now time.Time) bool {
+	if g.xfetchBeta <= 0 || e.delta <= 0 {
+		return false
+	}
+	gap := -float64(e.delta) * g.xfetchBeta * math.Log(1-rand.Float64())
+	return now.Add(time.Duration(gap)).After(e.expire)
+}
+
+// refreshAsync 在后台重新加载key 同一个key同时只会有一次刷新 已有刷新在进行时返回false
+// 刷新受加载超时限制 通过 WithLoadTimeout 关闭加载超时时使用默认的RPC超时 Retriever卡住也不会一直占着该key的航班
+func (g *Group) refreshAsync(key string) bool {
+	if _, loaded := g.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return false
+	}
+	g.stats.refreshes.Add(1)
+	timeout := g.flight.Timeout
+	if timeout <= 0 {
+		timeout = defaultRPCTimeout
+	}
+	go func() {
+		defer g.refreshing.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_, err := g.flight.Fly(ctx, key, func(ctx context.Context) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return g.getLocally(ctx, key)
+		})
+		if errors.Is(err, ErrNotFound) {
+			// 数据源中已经没有该key 旧值不应继续提供服务
+			g.cache.remove(key)
+			return
+		}
+		if err != nil {
+			g.logger.Warn("refresh failed, keep serving the stale value", "group", g.name, "key", key, "err", err)
+		}
+	}()
+	return true
+}
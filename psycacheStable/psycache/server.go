@@ -2,15 +2,13 @@ package psycache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Psychopath-H/psycache-master/psycacheStable/consistenthash"
 	"github.com/Psychopath-H/psycache-master/psycacheStable/registry"
-	"github.com/Psychopath-H/psycache-master/psycacheStable/tracer"
-	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
-	"github.com/uber/jaeger-client-go"
-	"github.com/uber/jaeger-client-go/config"
-	"log"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"net"
+	"net/http"
 	pb "psycachepb"
 	"strings"
 	"sync"
@@ -18,6 +16,9 @@ import (
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 // server 模块为psycache之间提供通信能力
@@ -25,15 +26,9 @@ import (
 // 至于找哪台主机 那是一致性哈希的工作了
 
 const (
-	defaultAddr     = "127.0.0.1:6324"
-	defaultReplicas = 50
-)
-
-var (
-	defaultEtcdConfig = clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 5 * time.Second,
-	}
+	defaultAddr        = "127.0.0.1:6324"
+	defaultReplicas    = 50
+	defaultServiceName = "psycache"
 )
 
 // server 和 Group 是解耦合的 所以server要自己实现并发控制
@@ -42,21 +37,53 @@ type server struct {
 
 	addr       string     // format: ip:port
 	status     bool       // true: running false: stop
-	stopSignal chan error // 通知registry revoke服务
+	stopSignal chan error // 关闭时通知registry revoke服务
 	mu         sync.Mutex
-	consHash   *consistenthash.Consistency
+	consHash   consistenthash.PeerPicker
 	clients    map[string]*client
+	discovery  registry.Discovery // 为nil时节点列表完全由SetPeers指定
+	stopWatch  context.CancelFunc // 停止监听节点变化
+
+	// 以下配置项由 ServerOption 设置
+	listenAddr     string                           // 监听地址 为空时监听addr端口上的所有网卡
+	serviceName    string                           // 注册至etcd的服务名
+	etcdConfig     clientv3.Config                  // 没有配置服务发现时 用于注册服务的etcd配置
+	replicas       int                              // 一致性哈希中每个节点的虚拟节点个数
+	newPicker      func() consistenthash.PeerPicker // 创建key到节点的映射策略 为空时使用哈希环
+	tracerProvider oteltrace.TracerProvider         // 为空时不开启链路追踪
+	serverCreds    credentials.TransportCredentials
+	clientCreds    credentials.TransportCredentials
+	grpcOpts       []grpc.ServerOption
+	metricsAddr    string       // 提供/metrics的http地址 为空时不开启
+	metricsSrv     *http.Server // 运行中的/metrics服务
+	logger         Logger
+	tracer         oteltrace.Tracer
 }
 
 // NewServer 创建cache的svr 若addr为空 则使用defaultAddr
-func NewServer(addr string) (*server, error) {
+// addr是其他节点访问本节点的地址 其余配置通过opts指定
+func NewServer(addr string, opts ...ServerOption) (*server, error) {
 	if addr == "" {
 		addr = defaultAddr
 	}
 	if !validPeerAddr(addr) {
 		return nil, fmt.Errorf("invalid addr %s, it should be x.x.x.x:port", addr)
 	}
-	return &server{addr: addr}, nil
+	s := &server{
+		addr:        addr,
+		serviceName: defaultServiceName,
+		etcdConfig:  registry.DefaultEtcdConfig,
+		replicas:    defaultReplicas,
+		logger:      nopLogger{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.tracer = noopTracer
+	if s.tracerProvider != nil {
+		s.tracer = s.tracerProvider.Tracer(tracerName)
+	}
+	return s, nil
 }
 
 // Get 实现PsyCache service的Get接口
@@ -64,7 +91,7 @@ func (s *server) Get(ctx context.Context, in *pb.GetRequest) (*pb.GetResponse, e
 	group, key := in.GetGroup(), in.GetKey()
 	resp := &pb.GetResponse{}
 
-	log.Printf("[psycache_svr %s] Recv RPC Request - (%s)/(%s)", s.addr, group, key)
+	s.logger.Debug("recv Get request", "addr", s.addr, "group", group, "key", key)
 	if key == "" {
 		return resp, fmt.Errorf("key required")
 	}
@@ -72,9 +99,13 @@ func (s *server) Get(ctx context.Context, in *pb.GetRequest) (*pb.GetResponse, e
 	if g == nil {
 		return resp, fmt.Errorf("group not found")
 	}
-	view, err := g.Get(key)
+	view, err := g.GetContext(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		// 以NotFound状态码告知调用方key不存在 使其可以负缓存
+		return resp, status.Error(codes.NotFound, err.Error())
+	}
 	if err != nil {
-		log.Printf("get %s succeed", key)
+		s.logger.Debug("get failed", "group", group, "key", key, "err", err)
 		return resp, err
 	}
 	resp.Value = view.ByteSlice()
@@ -86,7 +117,7 @@ func (s *server) Remove(ctx context.Context, in *pb.GetRequest) (*pb.RemoveRespo
 	group, key := in.GetGroup(), in.GetKey()
 	resp := &pb.RemoveResponse{}
 
-	log.Printf("[psycache_svr %s] Recv RPC Request - (%s)/(%s)", s.addr, group, key)
+	s.logger.Debug("recv Remove request", "addr", s.addr, "group", group, "key", key)
 	if key == "" {
 		return resp, fmt.Errorf("key required")
 	}
@@ -94,14 +125,75 @@ func (s *server) Remove(ctx context.Context, in *pb.GetRequest) (*pb.RemoveRespo
 	if g == nil {
 		return resp, fmt.Errorf("group not found")
 	}
-	err := g.Remove(key)
+	// 数据源已由发起请求的节点写回 这里只删除缓存
+	err := g.removeCache(ctx, key)
 	if err != nil {
-		log.Printf("remove %s succeed", key)
+		s.logger.Debug("remove failed", "group", group, "key", key, "err", err)
 		return resp, err
 	}
 	return resp, nil
 }
 
+// Set 实现PsyCache service的Set接口
+// 请求已由发起方路由到本节点 因此直接写入本地缓存 不再重新Pick
+func (s *server) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.SetResponse{}
+
+	s.logger.Debug("recv Set request", "addr", s.addr, "group", group, "key", key)
+	if key == "" {
+		return resp, fmt.Errorf("key required")
+	}
+	g := GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	g.setLocally(key, in.GetValue(), time.Duration(in.GetTtl())*time.Millisecond)
+	resp.Value = true
+	return resp, nil
+}
+
+// MultiGet 实现PsyCache service的MultiGet接口
+// 取回失败的key不会出现在响应中 由发起方自行决定如何处理
+func (s *server) MultiGet(ctx context.Context, in *pb.MultiGetRequest) (*pb.MultiGetResponse, error) {
+	group, keys := in.GetGroup(), in.GetKeys()
+	resp := &pb.MultiGetResponse{}
+
+	s.logger.Debug("recv MultiGet request", "addr", s.addr, "group", group, "keys", len(keys))
+	g := GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	views, notFound, err := g.getMany(ctx, keys)
+	if err != nil {
+		return resp, err
+	}
+	resp.Values = make(map[string][]byte, len(views))
+	for key, view := range views {
+		resp.Values[key] = view.ByteSlice()
+	}
+	resp.NotFound = notFound
+	return resp, nil
+}
+
+// MultiRemove 实现PsyCache service的MultiRemove接口
+func (s *server) MultiRemove(ctx context.Context, in *pb.MultiRemoveRequest) (*pb.MultiRemoveResponse, error) {
+	group, keys := in.GetGroup(), in.GetKeys()
+	resp := &pb.MultiRemoveResponse{}
+
+	s.logger.Debug("recv MultiRemove request", "addr", s.addr, "group", group, "keys", len(keys))
+	g := GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	// 数据源已由发起请求的节点写回 这里只删除缓存
+	if err := g.removeManyCache(ctx, keys); err != nil {
+		return resp, err
+	}
+	resp.Value = true
+	return resp, nil
+}
+
 // Start 启动cache服务
 func (s *server) Start() error {
 	s.mu.Lock()
@@ -111,7 +203,7 @@ func (s *server) Start() error {
 	}
 	// -----------------启动服务----------------------
 	// 1. 设置status为true 表示服务器已在运行
-	// 2. 初始化stop channal,这用于通知registry stop keep alive
+	// 2. 初始化stop channal,Stop时将其关闭 用于通知registry stop keep alive
 	// 3. 初始化tcp socket并开始监听
 	// 4. 注册rpc服务至grpc 这样grpc收到request可以分发给server处理
 	// 5. 将自己的服务名/Host地址注册至etcd 这样client可以通过etcd
@@ -121,48 +213,61 @@ func (s *server) Start() error {
 	s.status = true
 	s.stopSignal = make(chan error)
 
-	port := strings.Split(s.addr, ":")[1]
-	lis, err := net.Listen("tcp", ":"+port)
+	listenAddr := s.listenAddr
+	if listenAddr == "" {
+		listenAddr = ":" + strings.Split(s.addr, ":")[1]
+	}
+	lis, err := net.Listen("tcp", listenAddr)
 	if err != nil {
+		s.status = false
+		s.mu.Unlock()
 		return fmt.Errorf("failed to listen: %v", err)
 	}
 
-	Tracer, closer, err := tracer.CreateTracer("ScoreTracer", &config.SamplerConfig{
-		Type:  jaeger.SamplerTypeConst,
-		Param: 1,
-	}, &config.ReporterConfig{
-		LogSpans:          true,
-		CollectorEndpoint: "http://192.168.100.100:14268/api/traces",
-	}, config.Logger(jaeger.StdLogger))
-	if err != nil {
-		panic(err)
+	grpcOpts := append([]grpc.ServerOption{}, s.grpcOpts...)
+	if s.serverCreds != nil {
+		grpcOpts = append(grpcOpts, grpc.Creds(s.serverCreds))
+	}
+	grpcOpts = append(grpcOpts, grpc.ChainUnaryInterceptor(s.tracingInterceptor))
+
+	if s.metricsAddr != "" {
+		s.metricsSrv = s.serveMetrics()
 	}
-	defer closer.Close()
 
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(grpc_opentracing.UnaryServerInterceptor(grpc_opentracing.WithTracer(Tracer))))
+	grpcServer := grpc.NewServer(grpcOpts...)
 	pb.RegisterPsyCacheServer(grpcServer, s) //注册RPC服务至GRPC
 
-	// 注册服务至etcd
+	// 注册服务至服务发现组件
 	go func() {
 		// Register never return unless stop singnal received
-		err := registry.Register("psycache", s.addr, s.stopSignal)
+		var err error
+		switch r := s.discovery.(type) {
+		case nil: // 没有配置服务发现时 沿用etcd注册
+			err = registry.RegisterWithConfig(s.etcdConfig, s.logger, s.serviceName, s.addr, s.stopSignal)
+		case registry.Registrar:
+			err = r.Register(s.addr, s.stopSignal)
+		default: // 静态列表/配置文件无需注册 等待停止信号即可
+			err = <-s.stopSignal
+		}
 		if err != nil {
-			log.Fatalf(err.Error())
+			// 注册失败时节点仍可被静态配置的peer访问 等待停止信号后再退出
+			s.logger.Error("register service failed", "addr", s.addr, "err", err)
+			<-s.stopSignal
 		}
-		// Close channel
-		close(s.stopSignal)
 		// Close tcp listen
-		err = lis.Close()
-		if err != nil {
-			log.Fatalf(err.Error())
+		if err := lis.Close(); err != nil {
+			s.logger.Warn("close listener failed", "addr", s.addr, "err", err)
 		}
-		log.Printf("[%s] Revoke service and close tcp socket ok.", s.addr)
+		s.logger.Info("revoke service and close tcp socket ok", "addr", s.addr)
 	}()
 
-	//log.Printf("[%s] register service ok\n", s.addr)
 	s.mu.Unlock()
 
-	if err := grpcServer.Serve(lis); s.status && err != nil {
+	err = grpcServer.Serve(lis)
+	s.mu.Lock()
+	running := s.status // Stop关闭监听导致的错误不需要返回
+	s.mu.Unlock()
+	if running && err != nil {
 		return fmt.Errorf("failed to serve: %v", err)
 	}
 	return nil
@@ -176,15 +281,113 @@ func (s *server) SetPeers(peersAddr ...string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.consHash = consistenthash.New(defaultReplicas, nil)
-	s.consHash.Register(peersAddr...)
-	s.clients = make(map[string]*client)
+	wanted := make(map[string]bool, len(peersAddr))
 	for _, peerAddr := range peersAddr {
 		if !validPeerAddr(peerAddr) {
 			panic(fmt.Sprintf("[peer %s] invalid address format, it should be x.x.x.x:port", peerAddr))
 		}
-		service := fmt.Sprintf("psycache/%s", peerAddr)
-		s.clients[peerAddr] = NewClient(service)
+		wanted[peerAddr] = true
+	}
+	// 增量更新映射策略 只有离开与加入的节点涉及的key会发生迁移
+	if s.consHash == nil {
+		s.consHash = s.pickerFactory()()
+	}
+	for _, peerAddr := range s.consHash.Peers() {
+		if !wanted[peerAddr] {
+			s.consHash.Remove(peerAddr)
+		}
+	}
+	s.consHash.Register(peersAddr...)
+
+	clients := make(map[string]*client)
+	for _, peerAddr := range peersAddr {
+		// 仍在集群中的节点沿用原有连接
+		if c, ok := s.clients[peerAddr]; ok {
+			clients[peerAddr] = c
+			delete(s.clients, peerAddr)
+			continue
+		}
+		clients[peerAddr] = NewClient(peerAddr, s.dialOptions()...)
+	}
+	// 关闭已离开集群的节点的连接
+	s.closeClients(s.clients)
+	s.clients = clients
+}
+
+// dialOptions 返回与其他节点建立连接时使用的grpc配置
+func (s *server) dialOptions() []grpc.DialOption {
+	if s.clientCreds != nil {
+		return []grpc.DialOption{grpc.WithTransportCredentials(s.clientCreds)}
+	}
+	return []grpc.DialOption{grpc.WithInsecure()}
+}
+
+// closeClients 关闭所有与远端节点的连接
+func (s *server) closeClients(clients map[string]*client) {
+	for _, c := range clients {
+		if err := c.Close(); err != nil {
+			s.logger.Warn("close connection failed", "peer", c.addr, "err", err)
+		}
+	}
+}
+
+// SetDiscovery 通过服务发现获取节点列表 并在节点变化时自动重建一致性哈希环
+// 该方法会阻塞直至拿到第一份节点列表 之后的变化在后台应用
+func (s *server) SetDiscovery(d registry.Discovery) error {
+	if l, ok := d.(interface{ SetLogger(registry.Logger) }); ok {
+		l.SetLogger(s.logger)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	peers, ok := <-ch
+	if !ok {
+		cancel()
+		return fmt.Errorf("discovery closed before any peer found")
+	}
+	s.mu.Lock()
+	if s.stopWatch != nil {
+		s.stopWatch()
+	}
+	s.discovery, s.stopWatch = d, cancel
+	s.mu.Unlock()
+	s.applyPeers(peers)
+
+	go func() {
+		for peers := range ch {
+			if ctx.Err() != nil {
+				return
+			}
+			s.applyPeers(peers)
+		}
+	}()
+	return nil
+}
+
+// applyPeers 应用服务发现给出的节点列表 格式不合法的节点将被忽略
+func (s *server) applyPeers(peers []string) {
+	valid := make([]string, 0, len(peers))
+	for _, peerAddr := range peers {
+		if !validPeerAddr(peerAddr) {
+			s.logger.Warn("invalid peer address format, ignored", "peer", peerAddr)
+			continue
+		}
+		valid = append(valid, peerAddr)
+	}
+	s.logger.Info("peers changed", "addr", s.addr, "peers", valid)
+	s.SetPeers(valid...)
+}
+
+// pickerFactory 返回创建映射策略的函数 默认使用带虚拟节点的哈希环
+func (s *server) pickerFactory() func() consistenthash.PeerPicker {
+	if s.newPicker != nil {
+		return s.newPicker
+	}
+	return func() consistenthash.PeerPicker {
+		return consistenthash.New(s.replicas, nil)
 	}
 }
 
@@ -194,26 +397,39 @@ func (s *server) Pick(key string) (Fetcher, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.consHash == nil { // 尚未配置节点
+		return nil, false
+	}
 	peerAddr := s.consHash.GetPeer(key)
 	// Pick itself
-	if peerAddr == s.addr {
-		log.Printf("ooh! pick myself, I am %s\n", s.addr)
+	if peerAddr == "" || peerAddr == s.addr {
+		s.logger.Debug("pick self", "addr", s.addr, "key", key)
 		return nil, false
 	}
-	log.Printf("[cache %s] pick remote peer: %s\n", s.addr, peerAddr)
+	s.logger.Debug("pick remote peer", "addr", s.addr, "key", key, "peer", peerAddr)
 	return s.clients[peerAddr], true
 }
 
 // Stop 停止server运行 如果server没有运行 这将是一个no-op
 func (s *server) Stop() {
 	s.mu.Lock()
+	if s.stopWatch != nil { // 停止监听节点变化
+		s.stopWatch()
+		s.stopWatch = nil
+	}
 	if s.status == false {
 		s.mu.Unlock()
 		return
 	}
-	s.stopSignal <- nil // 发送停止keepalive信号
-	s.status = false    // 设置server运行状态为stop
-	s.clients = nil     // 清空一致性哈希信息 有助于垃圾回收
+	// 关闭而不是发送停止keepalive信号 注册goroutine仍阻塞在etcd上时Stop也不会被卡住
+	close(s.stopSignal)
+	s.status = false // 设置server运行状态为stop
+	s.closeClients(s.clients)
+	if s.metricsSrv != nil {
+		s.metricsSrv.Close()
+		s.metricsSrv = nil
+	}
+	s.clients = nil // 清空一致性哈希信息 有助于垃圾回收
 	s.consHash = nil
 	s.mu.Unlock()
 }
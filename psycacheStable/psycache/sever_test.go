@@ -1,18 +1,28 @@
 package psycache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/consistenthash"
+	"github.com/Psychopath-H/psycache-master/psycacheStable/registry"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"log"
 	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-// 生成当前时间 + 20秒
-func initTime() int64 {
-	return time.Now().UnixNano()/1e6 + 20000
-}
+// 缓存从写入起存活20秒
+const defaultTTL = 20 * time.Second
 
 func createTestSvr() (*Group, *server) {
 	mysql := map[string]string{
@@ -28,7 +38,7 @@ func createTestSvr() (*Group, *server) {
 				return []byte(v), nil
 			}
 			return nil, fmt.Errorf("%s not exist", key)
-		}), TYPE_LRU, initTime(), 2)
+		}), TYPE_LRU, defaultTTL, 2)
 
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	// 随机一个端口 避免冲突
@@ -81,3 +91,383 @@ func TestServer_GetUnknownKey(t *testing.T) {
 	}
 	DestroyGroup(g.name)
 }
+
+func TestServer_Discovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	if err := os.WriteFile(path, []byte(`["localhost:9001"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	svr, err := NewServer("localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.SetDiscovery(registry.NewFileDiscovery(path, 10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Stop()
+	if _, ok := svr.Pick("Tom"); ok {
+		t.Fatal("only itself in the ring, should pick itself")
+	}
+
+	// 新节点加入后 哈希环应自动重建
+	if err := os.WriteFile(path, []byte(`["localhost:9001", "localhost:9002", "localhost:9003"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for i := 0; i < 100; i++ {
+			if _, ok := svr.Pick(fmt.Sprintf("key%d", i)); ok {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("ring should be rebuilt after peers changed")
+}
+
+func TestClient_ReuseConn(t *testing.T) {
+	g := NewGroup("scores-conn", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}), TYPE_LRU, defaultTTL, 2)
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	addr := fmt.Sprintf("localhost:%d", 50100+r.Intn(100))
+	svr, err := NewServer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.SetDiscovery(registry.NewStaticDiscovery(addr)); err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterSvr(svr)
+	go svr.Start()
+	defer DestroyGroup(g.name)
+
+	c := NewClient(addr)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for {
+		if _, err = c.Fetch(ctx, g.name, "Tom"); err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := c.conn
+	if view, err := c.Fetch(ctx, g.name, "Tom"); err != nil || string(view) != "630" {
+		t.Fatalf("fetch Tom failed, %v", err)
+	}
+	if c.conn != conn {
+		t.Fatal("connection should be reused across calls")
+	}
+}
+
+func TestServer_SetPeersClosesDroppedClients(t *testing.T) {
+	svr, err := NewServer("localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.SetPeers("localhost:9001", "localhost:9002", "localhost:9003")
+	kept, dropped := svr.clients["localhost:9002"], svr.clients["localhost:9003"]
+	if _, err := dropped.getConn(); err != nil {
+		t.Fatal(err)
+	}
+	svr.SetPeers("localhost:9001", "localhost:9002")
+	if svr.clients["localhost:9002"] != kept {
+		t.Fatal("client of remaining peer should be reused")
+	}
+	if dropped.conn != nil {
+		t.Fatal("connection of dropped peer should be closed")
+	}
+	// 仍持有被移除client的请求不会重新建立连接
+	if _, err := dropped.Fetch(context.Background(), "scores", "Tom"); !errors.Is(err, errClientClosed) || dropped.conn != nil {
+		t.Fatalf("closed client should not redial, %v", err)
+	}
+}
+
+func TestServer_Options(t *testing.T) {
+	svr, err := NewServer("localhost:9001",
+		WithReplicas(10),
+		WithListenAddr("127.0.0.1:-1"),
+		WithServiceName("psycache-test"),
+		WithEtcdEndpoints("127.0.0.1:12379"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svr.replicas != 10 || svr.serviceName != "psycache-test" || svr.etcdConfig.Endpoints[0] != "127.0.0.1:12379" {
+		t.Fatal("options should be applied")
+	}
+	if registry.DefaultEtcdConfig.Endpoints[0] != "localhost:2379" {
+		t.Fatal("options should not modify the default etcd config")
+	}
+	// 监听失败后server应回到可用状态
+	if err := svr.Start(); err == nil {
+		t.Fatal("listen on an invalid address should fail")
+	}
+	svr.Stop()
+}
+
+// etcd不可达时 注册在DialTimeout后放弃 Stop不会被阻塞
+func TestServer_StopWithoutEtcd(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	addr := fmt.Sprintf("localhost:%d", 50600+r.Intn(100))
+	svr, err := NewServer(addr, WithEtcdConfig(clientv3.Config{
+		Endpoints:   []string{"127.0.0.1:1"},
+		DialTimeout: 50 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan error, 1)
+	go func() { started <- svr.Start() }()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		svr.mu.Lock()
+		running := svr.status
+		svr.mu.Unlock()
+		if running {
+			break
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		svr.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop should not block on an unreachable etcd")
+	}
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start should return once registration gives up")
+	}
+}
+
+func TestServer_PeerPicker(t *testing.T) {
+	svr, err := NewServer("localhost:9001", WithPeerPicker(func() consistenthash.PeerPicker {
+		return consistenthash.NewRendezvous(nil)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := []string{"localhost:9001", "localhost:9002", "localhost:9003"}
+	svr.SetPeers(peers...)
+	defer svr.closeClients(svr.clients)
+	if _, ok := svr.consHash.(*consistenthash.Rendezvous); !ok {
+		t.Fatalf("Actual: %T\tExpect: *consistenthash.Rendezvous", svr.consHash)
+	}
+	expect := consistenthash.NewRendezvous(nil)
+	expect.Register(peers...)
+	for _, key := range []string{"Tom", "Jack", "Sam"} {
+		_, remote := svr.Pick(key)
+		if remote != (expect.GetPeer(key) != "localhost:9001") {
+			t.Fatalf("key %s picked the wrong peer", key)
+		}
+	}
+}
+
+func TestTTLMillis(t *testing.T) {
+	for ttl, expect := range map[time.Duration]int64{
+		0:                       0,
+		-time.Second:            0,
+		time.Nanosecond:         1,
+		time.Millisecond:        1,
+		time.Millisecond + 1:    2,
+		1500 * time.Microsecond: 2,
+		time.Second:             1000,
+	} {
+		if actual := ttlMillis(ttl); actual != expect {
+			t.Fatalf("ttlMillis(%v) Actual: %d\tExpect: %d", ttl, actual, expect)
+		}
+	}
+}
+
+func TestClient_FetchMany(t *testing.T) {
+	g := NewGroup("scores-multi", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			switch key {
+			case "Unknown":
+				return nil, fmt.Errorf("%s not exist", key)
+			case "Missing":
+				return nil, ErrNotFound
+			}
+			return []byte("v-" + key), nil
+		}), TYPE_LRU, defaultTTL, 2)
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	addr := fmt.Sprintf("localhost:%d", 50300+r.Intn(100))
+	svr, err := NewServer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.SetDiscovery(registry.NewStaticDiscovery(addr)); err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterSvr(svr)
+	go svr.Start()
+	defer DestroyGroup(g.name)
+
+	c := NewClient(addr)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var values map[string][]byte
+	var notFound []string
+	for {
+		if values, notFound, err = c.FetchMany(ctx, g.name, []string{"Tom", "Jack", "Unknown", "Missing"}); err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || string(values["Tom"]) != "v-Tom" || string(values["Jack"]) != "v-Jack" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	// 取回失败的key不算作不存在
+	if len(notFound) != 1 || notFound[0] != "Missing" {
+		t.Fatalf("Actual: %v\tExpect: [Missing]", notFound)
+	}
+	if err := c.RemoveMany(ctx, g.name, []string{"Tom", "Jack"}); err != nil {
+		t.Fatal(err)
+	}
+	if g.cache.contains("Tom") || g.cache.contains("Jack") {
+		t.Fatal("keys should be removed by MultiRemove")
+	}
+}
+
+func TestClient_FetchNotFound(t *testing.T) {
+	var retrieves atomic.Int64
+	g := NewGroup("scores-notfound", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			retrieves.Add(1)
+			return nil, ErrNotFound
+		}), TYPE_LRU, defaultTTL, 2, WithNegativeTTL(5*time.Second))
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	addr := fmt.Sprintf("localhost:%d", 50500+r.Intn(100))
+	svr, err := NewServer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.SetDiscovery(registry.NewStaticDiscovery(addr)); err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterSvr(svr)
+	go svr.Start()
+	defer DestroyGroup(g.name)
+
+	c := NewClient(addr)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for {
+		if _, err = c.Fetch(ctx, g.name, "Unknown"); errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Actual: %v\tExpect: %v", err, ErrNotFound)
+	}
+	// 第二次请求命中远端的负缓存
+	if _, err = c.Fetch(ctx, g.name, "Unknown"); !errors.Is(err, ErrNotFound) || retrieves.Load() != 1 {
+		t.Fatalf("Actual: %v %d\tExpect: %v 1", err, retrieves.Load(), ErrNotFound)
+	}
+}
+
+func TestServer_MetricsHandler(t *testing.T) {
+	g := NewGroup("scores-metrics", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}), TYPE_LRU, defaultTTL, 2)
+	defer func() {
+		mu.Lock()
+		delete(groups, g.name)
+		mu.Unlock()
+	}()
+	g.Get("Tom")
+	g.Get("Tom")
+
+	svr, err := NewServer("localhost:9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.SetPeers("localhost:9001", "localhost:9002")
+	defer svr.closeClients(svr.clients)
+
+	rec := httptest.NewRecorder()
+	svr.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, metric := range []string{
+		`psycache_group_gets_total{group="scores-metrics"} 2`,
+		`psycache_group_hits_total{cache="main",group="scores-metrics"} 1`,
+		`psycache_group_hit_ratio{group="scores-metrics"} 0.5`,
+		`psycache_group_loads_total{group="scores-metrics",result="ok",source="retriever"} 1`,
+		`psycache_group_load_duration_seconds_count{group="scores-metrics",source="retriever"} 1`,
+		`psycache_cache_entries{cache="main",group="scores-metrics"} 1`,
+		`psycache_peers{addr="localhost:9001"} 2`,
+	} {
+		if !strings.Contains(body, metric) {
+			t.Fatalf("metric %s not found in\n%s", metric, body)
+		}
+	}
+}
+
+// TestServer_TracePropagation 远端节点的span应与调用方属于同一条链路
+func TestServer_TracePropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	g := NewGroup("scores-trace", 2<<10, RetrieverFunc(
+		func(key string) ([]byte, error) {
+			return []byte("630"), nil
+		}), TYPE_LRU, defaultTTL, 2)
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	addr := fmt.Sprintf("localhost:%d", 50400+r.Intn(100))
+	svr, err := NewServer(addr, WithServerTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.SetDiscovery(registry.NewStaticDiscovery(addr)); err != nil {
+		t.Fatal(err)
+	}
+	g.RegisterSvr(svr)
+	go svr.Start()
+	defer DestroyGroup(g.name)
+
+	c := NewClient(addr)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ctx, root := tp.Tracer("test").Start(ctx, "root")
+	for {
+		if _, err = c.Fetch(ctx, g.name, "Tom"); err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	root.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool)
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() != root.SpanContext().TraceID() {
+			t.Fatalf("span %s belongs to another trace", span.Name)
+		}
+		names[span.Name] = true
+	}
+	for _, name := range []string{"/psycachepb.PsyCache/Get", "psycache.Group.Get", "psycache.Retriever"} {
+		if !names[name] {
+			t.Fatalf("span %s not found in %v", name, names)
+		}
+	}
+}
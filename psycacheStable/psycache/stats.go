@@ -0,0 +1,73 @@
+package psycache
+
+// stats 模块统计 Group 的访问情况 计数器均为原子操作 可在任意时刻并发读取
+
+import (
+	cacheAlg "github.com/Psychopath-H/psycache-master/psycacheStable/cachealgorithm"
+	"sync/atomic"
+)
+
+// Stats 是 Group 统计信息的快照
+type Stats struct {
+	Gets            int64 // Get请求的key数 包括GetMany中的每个key
+	LocalHits       int64 // 本地命中次数 包括热点缓存与负缓存命中
+	HotHits         int64 // 热点缓存命中次数
+	NegativeHits    int64 // 负缓存命中次数 即直接判定key不存在的次数
+	BloomRejects    int64 // 被布隆过滤器判定不存在而拒绝的key数
+	StaleHits       int64 // 在宽限期内返回已过期旧值的次数 计入LocalHits
+	Refreshes       int64 // 后台刷新的次数 包括stale-while-revalidate、refresh-ahead与提前过期
+	EarlyRefreshes  int64 // 由提前过期(XFetch)触发刷新的次数
+	PeerLoads       int64 // 从远端peer成功取回的key数
+	PeerErrors      int64 // 从远端peer取回失败的key数
+	RetrieverLoads  int64 // 从Retriever成功取回的key数
+	RetrieverErrors int64 // 从Retriever取回失败的key数
+	DedupedLoads    int64 // 被singleflight合并 搭乘了其他请求航班的加载次数
+
+	MainCache cacheAlg.Stats // 本节点负责的key
+	HotCache  cacheAlg.Stats // 热点缓存 未开启时为零值
+	NegCache  cacheAlg.Stats // 负缓存 未开启时为零值
+}
+
+// groupStats 是 Group 内部使用的计数器
+type groupStats struct {
+	gets            atomic.Int64
+	localHits       atomic.Int64
+	hotHits         atomic.Int64
+	negativeHits    atomic.Int64
+	bloomRejects    atomic.Int64
+	staleHits       atomic.Int64
+	refreshes       atomic.Int64
+	earlyRefreshes  atomic.Int64
+	peerLoads       atomic.Int64
+	peerErrors      atomic.Int64
+	retrieverLoads  atomic.Int64
+	retrieverErrors atomic.Int64
+	dedupedLoads    atomic.Int64
+}
+
+// Stats 返回 Group 当前的统计信息
+func (g *Group) Stats() Stats {
+	stats := Stats{
+		Gets:            g.stats.gets.Load(),
+		LocalHits:       g.stats.localHits.Load(),
+		HotHits:         g.stats.hotHits.Load(),
+		NegativeHits:    g.stats.negativeHits.Load(),
+		BloomRejects:    g.stats.bloomRejects.Load(),
+		StaleHits:       g.stats.staleHits.Load(),
+		Refreshes:       g.stats.refreshes.Load(),
+		EarlyRefreshes:  g.stats.earlyRefreshes.Load(),
+		PeerLoads:       g.stats.peerLoads.Load(),
+		PeerErrors:      g.stats.peerErrors.Load(),
+		RetrieverLoads:  g.stats.retrieverLoads.Load(),
+		RetrieverErrors: g.stats.retrieverErrors.Load(),
+		DedupedLoads:    g.stats.dedupedLoads.Load(),
+		MainCache:       g.cache.stats(),
+	}
+	if g.hotCache != nil {
+		stats.HotCache = g.hotCache.stats()
+	}
+	if g.negCache != nil {
+		stats.NegCache = g.negCache.stats()
+	}
+	return stats
+}
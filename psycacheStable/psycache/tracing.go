@@ -0,0 +1,101 @@
+package psycache
+
+// tracing 模块基于OpenTelemetry提供链路追踪 默认使用no-op的TracerProvider 不产生任何开销
+// trace context通过grpc metadata在节点间传递 因此一次未命中会在各节点上串成同一条链路
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// tracerName 是psycache创建的span所属的instrumentation名称
+const tracerName = "github.com/Psychopath-H/psycache-master/psycacheStable/psycache"
+
+// propagator 在节点间传递W3C trace context 与全局propagator的配置无关
+var propagator = propagation.TraceContext{}
+
+// noopTracer 在没有配置TracerProvider时使用
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// span属性
+const (
+	attrGroup  = attribute.Key("psycache.group")
+	attrKey    = attribute.Key("psycache.key")
+	attrKeys   = attribute.Key("psycache.keys")
+	attrHit    = attribute.Key("psycache.cache_hit")
+	attrShared = attribute.Key("psycache.singleflight.shared")
+	attrPeer   = attribute.Key("psycache.peer")
+)
+
+// startSpan 以ctx中的span为父span创建新的span
+func (g *Group) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, oteltrace.Span) {
+	tracer := g.tracer
+	if tracer == nil {
+		tracer = noopTracer
+	}
+	attrs = append(attrs, attrGroup.String(g.name))
+	return tracer.Start(ctx, name, oteltrace.WithAttributes(attrs...))
+}
+
+// endSpan 记录err并结束span
+func endSpan(span oteltrace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// metadataCarrier 使grpc metadata可以承载trace context
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// injectTraceContext 将ctx中的trace context写入请求的metadata
+func injectTraceContext(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, metadataCarrier(md))
+	return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+}
+
+// tracingInterceptor 从请求的metadata中恢复trace context 并为请求创建server span
+func (s *server) tracingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = propagator.Extract(ctx, metadataCarrier(md))
+	}
+	ctx, span := s.tracer.Start(ctx, info.FullMethod,
+		oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(attrPeer.String(s.addr)))
+	resp, err := handler(ctx, req)
+	endSpan(span, err)
+	return resp, err
+}
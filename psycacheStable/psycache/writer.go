@@ -0,0 +1,277 @@
+package psycache
+
+// writer 模块提供将 Group.Set/Group.Remove 写回数据源的能力
+// write-through模式下先同步写数据源 成功后再更新缓存
+// write-behind模式下写操作先进入有界队列 由后台协程攒批写回 失败时按指数退避重试 DestroyGroup时会写完队列中剩余的操作
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Writer 要求对象实现将数据写回数据源的能力
+type Writer interface {
+	Write(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+// WriteOp 是一次写回操作 Delete为true时删除key
+type WriteOp struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+// BatchWriter 要求对象实现批量写回的能力 write-behind模式下每批操作只调用一次 WriteBatch
+// 未实现时逐个调用 Writer 的 Write/Delete
+type BatchWriter interface {
+	Writer
+	WriteBatch(ctx context.Context, ops []WriteOp) error
+}
+
+// WriteBehindConfig 是write-behind模式的配置 值为0的字段使用默认值
+type WriteBehindConfig struct {
+	QueueSize     int           // 队列容量 队列满时写操作阻塞直至ctx结束 默认1024
+	BatchSize     int           // 每批最多写回的操作数 默认64
+	FlushInterval time.Duration // 攒批的最长等待时间 默认100ms
+	MaxRetries    int           // 写回失败时的最大重试次数 默认3
+	RetryBackoff  time.Duration // 第一次重试前的等待时间 之后每次翻倍 默认100ms
+	WriteTimeout  time.Duration // 每次调用 WriteBatch 或 Write/Delete 的超时时间 默认5s 保证销毁时的写回一定会结束
+}
+
+const (
+	defaultWriteQueueSize     = 1024
+	defaultWriteBatchSize     = 64
+	defaultWriteFlushInterval = 100 * time.Millisecond
+	defaultWriteMaxRetries    = 3
+	defaultWriteRetryBackoff  = 100 * time.Millisecond
+	defaultWriteTimeout       = 5 * time.Second
+)
+
+// ErrWriterClosed 在 Group 被销毁后继续写入时返回
+var ErrWriterClosed = errors.New("psycache: writer closed")
+
+// writeToSource 将value写回数据源
+func (g *Group) writeToSource(ctx context.Context, key string, value []byte) error {
+	return g.applyWrite(ctx, WriteOp{Key: key, Value: cloneBytes(value)})
+}
+
+// deleteFromSource 从数据源删除key
+func (g *Group) deleteFromSource(ctx context.Context, key string) error {
+	return g.applyWrite(ctx, WriteOp{Key: key, Delete: true})
+}
+
+// applyWrite 按写回模式处理一次写操作 没有配置Writer时什么也不做
+func (g *Group) applyWrite(ctx context.Context, op WriteOp) error {
+	if g.writer == nil {
+		return nil
+	}
+	if g.writeBehind != nil {
+		return g.writeBehind.enqueue(ctx, op)
+	}
+	ctx, span := g.startSpan(ctx, "psycache.Writer", attrKey.String(op.Key))
+	err := applyOp(ctx, g.writer, op)
+	endSpan(span, err)
+	return err
+}
+
+// Flush 阻塞直至write-behind队列中已有的操作全部写回 没有开启write-behind时直接返回
+func (g *Group) Flush(ctx context.Context) error {
+	if g.writeBehind == nil {
+		return nil
+	}
+	return g.writeBehind.flush(ctx)
+}
+
+func applyOp(ctx context.Context, writer Writer, op WriteOp) error {
+	if op.Delete {
+		return writer.Delete(ctx, op.Key)
+	}
+	return writer.Write(ctx, op.Key, op.Value)
+}
+
+// writeRequest 是队列中的元素 flushed不为空时代表一次Flush请求
+type writeRequest struct {
+	op      WriteOp
+	flushed chan struct{}
+}
+
+// writeBehind 在后台攒批写回数据源
+type writeBehind struct {
+	group  string
+	writer Writer
+	config WriteBehindConfig
+	logger Logger
+
+	mu     sync.RWMutex // 保护closed 保证关闭队列后不会再有写入
+	closed bool
+	queue  chan writeRequest
+	done   chan struct{} // 后台协程退出后关闭
+}
+
+func newWriteBehind(group string, writer Writer, config WriteBehindConfig, logger Logger) *writeBehind {
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultWriteQueueSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultWriteBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultWriteFlushInterval
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = defaultWriteMaxRetries
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaultWriteRetryBackoff
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaultWriteTimeout
+	}
+	w := &writeBehind{
+		group:  group,
+		writer: writer,
+		config: config,
+		logger: logger,
+		queue:  make(chan writeRequest, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// enqueue 将写操作放入队列 队列满时阻塞直至ctx结束
+func (w *writeBehind) enqueue(ctx context.Context, op WriteOp) error {
+	return w.send(ctx, writeRequest{op: op})
+}
+
+// flush 等待队列中已有的操作全部写回
+func (w *writeBehind) flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	if err := w.send(ctx, writeRequest{flushed: flushed}); err != nil {
+		return err
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *writeBehind) send(ctx context.Context, req writeRequest) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	select {
+	case w.queue <- req:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close 停止接收新的写操作 并阻塞直至队列中剩余的操作全部写回
+func (w *writeBehind) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// run 从队列中攒批 批满或等待超过FlushInterval时写回
+func (w *writeBehind) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]WriteOp, 0, w.config.BatchSize)
+	for {
+		select {
+		case req, ok := <-w.queue:
+			if !ok { // 队列已关闭 写完剩余的操作后退出
+				w.write(batch)
+				return
+			}
+			if req.flushed != nil {
+				w.write(batch)
+				batch = batch[:0]
+				close(req.flushed)
+				continue
+			}
+			batch = append(batch, req.op)
+			if len(batch) >= w.config.BatchSize {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// write 写回一批操作 失败的操作按指数退避重试 超过重试次数后丢弃并记录日志
+func (w *writeBehind) write(batch []WriteOp) {
+	ops := coalesce(batch)
+	backoff := w.config.RetryBackoff
+	for attempt := 0; len(ops) > 0; attempt++ {
+		var err error
+		ops, err = w.apply(ops)
+		if err == nil {
+			return
+		}
+		if attempt >= w.config.MaxRetries {
+			w.logger.Error("write behind failed, ops dropped", "group", w.group, "ops", len(ops), "err", err)
+			return
+		}
+		w.logger.Warn("write behind failed, retry later", "group", w.group, "ops", len(ops), "attempt", attempt+1, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// apply 写回ops 返回需要重试的操作 每次调用Writer最多等待WriteTimeout
+func (w *writeBehind) apply(ops []WriteOp) ([]WriteOp, error) {
+	if batchWriter, ok := w.writer.(BatchWriter); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), w.config.WriteTimeout)
+		defer cancel()
+		if err := batchWriter.WriteBatch(ctx, ops); err != nil {
+			return ops, err
+		}
+		return nil, nil
+	}
+	for i, op := range ops {
+		ctx, cancel := context.WithTimeout(context.Background(), w.config.WriteTimeout)
+		err := applyOp(ctx, w.writer, op)
+		cancel()
+		if err != nil {
+			return ops[i:], err
+		}
+	}
+	return nil, nil
+}
+
+// coalesce 同一批中对同一个key的多次操作只保留最后一次 其余操作保持原有顺序
+func coalesce(batch []WriteOp) []WriteOp {
+	last := make(map[string]int, len(batch))
+	for i, op := range batch {
+		last[op.Key] = i
+	}
+	ops := make([]WriteOp, 0, len(last))
+	for i, op := range batch {
+		if last[op.Key] == i {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
@@ -19,8 +19,11 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	PsyCache_Get_FullMethodName    = "/psycachepb.PsyCache/Get"
-	PsyCache_Remove_FullMethodName = "/psycachepb.PsyCache/Remove"
+	PsyCache_Get_FullMethodName         = "/psycachepb.PsyCache/Get"
+	PsyCache_Remove_FullMethodName      = "/psycachepb.PsyCache/Remove"
+	PsyCache_Set_FullMethodName         = "/psycachepb.PsyCache/Set"
+	PsyCache_MultiGet_FullMethodName    = "/psycachepb.PsyCache/MultiGet"
+	PsyCache_MultiRemove_FullMethodName = "/psycachepb.PsyCache/MultiRemove"
 )
 
 // PsyCacheClient is the client API for PsyCache service.
@@ -29,6 +32,9 @@ const (
 type PsyCacheClient interface {
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Remove(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error)
+	MultiGet(ctx context.Context, in *MultiGetRequest, opts ...grpc.CallOption) (*MultiGetResponse, error)
+	MultiRemove(ctx context.Context, in *MultiRemoveRequest, opts ...grpc.CallOption) (*MultiRemoveResponse, error)
 }
 
 type psyCacheClient struct {
@@ -57,12 +63,42 @@ func (c *psyCacheClient) Remove(ctx context.Context, in *GetRequest, opts ...grp
 	return out, nil
 }
 
+func (c *psyCacheClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error) {
+	out := new(SetResponse)
+	err := c.cc.Invoke(ctx, PsyCache_Set_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *psyCacheClient) MultiGet(ctx context.Context, in *MultiGetRequest, opts ...grpc.CallOption) (*MultiGetResponse, error) {
+	out := new(MultiGetResponse)
+	err := c.cc.Invoke(ctx, PsyCache_MultiGet_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *psyCacheClient) MultiRemove(ctx context.Context, in *MultiRemoveRequest, opts ...grpc.CallOption) (*MultiRemoveResponse, error) {
+	out := new(MultiRemoveResponse)
+	err := c.cc.Invoke(ctx, PsyCache_MultiRemove_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PsyCacheServer is the server API for PsyCache service.
 // All implementations must embed UnimplementedPsyCacheServer
 // for forward compatibility
 type PsyCacheServer interface {
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Remove(context.Context, *GetRequest) (*RemoveResponse, error)
+	Set(context.Context, *SetRequest) (*SetResponse, error)
+	MultiGet(context.Context, *MultiGetRequest) (*MultiGetResponse, error)
+	MultiRemove(context.Context, *MultiRemoveRequest) (*MultiRemoveResponse, error)
 	mustEmbedUnimplementedPsyCacheServer()
 }
 
@@ -76,6 +112,15 @@ func (UnimplementedPsyCacheServer) Get(context.Context, *GetRequest) (*GetRespon
 func (UnimplementedPsyCacheServer) Remove(context.Context, *GetRequest) (*RemoveResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
 }
+func (UnimplementedPsyCacheServer) Set(context.Context, *SetRequest) (*SetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
+}
+func (UnimplementedPsyCacheServer) MultiGet(context.Context, *MultiGetRequest) (*MultiGetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MultiGet not implemented")
+}
+func (UnimplementedPsyCacheServer) MultiRemove(context.Context, *MultiRemoveRequest) (*MultiRemoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MultiRemove not implemented")
+}
 func (UnimplementedPsyCacheServer) mustEmbedUnimplementedPsyCacheServer() {}
 
 // UnsafePsyCacheServer may be embedded to opt out of forward compatibility for this service.
@@ -125,6 +170,60 @@ func _PsyCache_Remove_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PsyCache_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PsyCacheServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PsyCache_Set_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PsyCacheServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PsyCache_MultiGet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MultiGetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PsyCacheServer).MultiGet(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PsyCache_MultiGet_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PsyCacheServer).MultiGet(ctx, req.(*MultiGetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PsyCache_MultiRemove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MultiRemoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PsyCacheServer).MultiRemove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PsyCache_MultiRemove_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PsyCacheServer).MultiRemove(ctx, req.(*MultiRemoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PsyCache_ServiceDesc is the grpc.ServiceDesc for PsyCache service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -140,6 +239,18 @@ var PsyCache_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Remove",
 			Handler:    _PsyCache_Remove_Handler,
 		},
+		{
+			MethodName: "Set",
+			Handler:    _PsyCache_Set_Handler,
+		},
+		{
+			MethodName: "MultiGet",
+			Handler:    _PsyCache_MultiGet_Handler,
+		},
+		{
+			MethodName: "MultiRemove",
+			Handler:    _PsyCache_MultiRemove_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "psycachepb.proto",
@@ -1,28 +1,113 @@
 package registry
 
 import (
-	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
-	"github.com/opentracing/opentracing-go"
+	"context"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
 	"go.etcd.io/etcd/client/v3/naming/resolver"
 	"google.golang.org/grpc"
+	"log/slog"
+	"sort"
 )
 
 // EtcdDial 向grpc请求一个服务
 // 通过提供一个etcd client和service name即可获得Connection
-func EtcdDial(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
+// opts会追加在默认配置之后 例如用于注入链路追踪的拦截器
+func EtcdDial(c *clientv3.Client, service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return EtcdDialContext(context.Background(), c, service, opts...)
+}
+
+// EtcdDialContext 与 EtcdDial 相同 但阻塞建立连接的过程受ctx的deadline与取消控制
+func EtcdDialContext(ctx context.Context, c *clientv3.Client, service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	etcdResolver, err := resolver.NewBuilder(c)
 	if err != nil {
 		return nil, err
 	}
-	Tracer := opentracing.GlobalTracer()
 
-	return grpc.Dial(
+	return grpc.DialContext(
+		ctx,
 		"etcd:///"+service,
-		grpc.WithResolvers(etcdResolver),
-		grpc.WithUnaryInterceptor(grpc_opentracing.UnaryClientInterceptor(
-			grpc_opentracing.WithTracer(Tracer))),
-		grpc.WithInsecure(),
-		grpc.WithBlock(),
+		append([]grpc.DialOption{
+			grpc.WithResolvers(etcdResolver),
+			grpc.WithInsecure(),
+			grpc.WithBlock(),
+		}, opts...)...,
 	)
 }
+
+// EtcdDiscovery 通过etcd发现注册在service下的所有节点
+type EtcdDiscovery struct {
+	cli     *clientv3.Client
+	service string
+	logger  Logger
+}
+
+// NewEtcdDiscovery 创建一个基于etcd的服务发现 cli的生命周期由调用方管理
+func NewEtcdDiscovery(cli *clientv3.Client, service string) *EtcdDiscovery {
+	return &EtcdDiscovery{cli: cli, service: service, logger: slog.Default()}
+}
+
+// SetLogger 指定注册服务时输出日志的Logger 默认为slog.Default()
+// server在 SetDiscovery 时会传入自己的Logger
+func (d *EtcdDiscovery) SetLogger(logger Logger) {
+	if logger != nil {
+		d.logger = logger
+	}
+}
+
+// Watch 监听service前缀下的endpoint变化 每次变化推送完整的节点列表
+func (d *EtcdDiscovery) Watch(ctx context.Context) (<-chan []string, error) {
+	em, err := endpoints.NewManager(d.cli, d.service)
+	if err != nil {
+		return nil, err
+	}
+	updates, err := em.NewWatchChannel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]string) // etcd key -> addr
+	apply := func(ups []*endpoints.Update) {
+		for _, up := range ups {
+			switch up.Op {
+			case endpoints.Add:
+				members[up.Key] = up.Endpoint.Addr
+			case endpoints.Delete:
+				delete(members, up.Key)
+			}
+		}
+	}
+	snapshot := func() []string {
+		peers := make([]string, 0, len(members))
+		for _, addr := range members {
+			peers = append(peers, addr)
+		}
+		sort.Strings(peers)
+		return peers
+	}
+	// 已注册的节点会在NewWatchChannel返回前放入channel
+	select {
+	case ups := <-updates:
+		apply(ups)
+	default:
+	}
+	ch := make(chan []string, 1)
+	ch <- snapshot()
+	go func() {
+		defer close(ch)
+		for ups := range updates {
+			apply(ups)
+			select {
+			case ch <- snapshot():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Register 将addr注册至etcd 在收到stop信号之前不会返回
+// 每次访问etcd最多等待 DefaultEtcdConfig 的DialTimeout
+func (d *EtcdDiscovery) Register(addr string, stop chan error) error {
+	return register(d.cli, 0, d.logger, d.service, addr, stop)
+}
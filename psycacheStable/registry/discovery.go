@@ -0,0 +1,168 @@
+package registry
+
+// discovery模块提供发现集群节点的能力
+// server通过 Discovery 感知节点变化 从而自动重建一致性哈希环
+// 除etcd外 还提供了静态列表与配置文件两种实现 方便在测试与没有etcd的边缘节点上运行
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultFileWatchInterval = time.Second
+
+// Discovery 定义了发现集群节点的能力
+type Discovery interface {
+	// Watch 返回集群节点列表的变化 每次推送的都是完整的节点列表(x.x.x.x:port)
+	// 第一次推送的是当前的节点列表 ctx结束后channel将被关闭
+	Watch(ctx context.Context) (<-chan []string, error)
+}
+
+// Registrar 定义了将自身注册至服务发现组件的能力
+// Register 在收到stop信号之前不会返回
+type Registrar interface {
+	Register(addr string, stop chan error) error
+}
+
+// Logger 定义了服务发现与注册输出日志的能力 *slog.Logger 与 psycache.Logger 均实现了该接口
+type Logger interface {
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+}
+
+// StaticDiscovery 使用一份固定的节点列表 节点不会发生变化
+type StaticDiscovery struct {
+	peers []string
+}
+
+// NewStaticDiscovery 创建一个固定节点列表的服务发现
+func NewStaticDiscovery(peers ...string) *StaticDiscovery {
+	return &StaticDiscovery{peers: append([]string(nil), peers...)}
+}
+
+// Watch 推送一次节点列表 之后等待ctx结束
+func (d *StaticDiscovery) Watch(ctx context.Context) (<-chan []string, error) {
+	ch := make(chan []string, 1)
+	ch <- append([]string(nil), d.peers...)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// FileDiscovery 从JSON/YAML配置文件中读取节点列表 并定期检查文件是否变化
+// 文件格式为 {"peers": ["x.x.x.x:port", ...]} 或直接是一个节点数组
+// 扩展名为.yaml/.yml时按YAML解析 否则按JSON解析
+type FileDiscovery struct {
+	path     string
+	interval time.Duration
+	logger   Logger
+}
+
+// NewFileDiscovery 创建一个基于配置文件的服务发现 interval为检查文件变化的周期
+func NewFileDiscovery(path string, interval time.Duration) *FileDiscovery {
+	if interval <= 0 {
+		interval = defaultFileWatchInterval
+	}
+	return &FileDiscovery{path: path, interval: interval, logger: slog.Default()}
+}
+
+// SetLogger 指定重新读取配置文件失败时输出日志的Logger 默认为slog.Default()
+// server在 SetDiscovery 时会传入自己的Logger
+func (d *FileDiscovery) SetLogger(logger Logger) {
+	if logger != nil {
+		d.logger = logger
+	}
+}
+
+// Watch 读取一次节点列表 之后每隔interval检查一次 节点列表变化时推送
+// 文件暂时不可读或格式错误时保留上一次的节点列表
+func (d *FileDiscovery) Watch(ctx context.Context) (<-chan []string, error) {
+	peers, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []string, 1)
+	ch <- peers
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				latest, err := d.load()
+				if err != nil {
+					d.logger.Warn("reload discovery file failed", "path", d.path, "err", err)
+					continue
+				}
+				if equalPeers(peers, latest) {
+					continue
+				}
+				peers = latest
+				select {
+				case ch <- peers:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// load 读取并解析配置文件 返回排好序的节点列表
+func (d *FileDiscovery) load() ([]string, error) {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+	unmarshal := json.Unmarshal
+	switch strings.ToLower(filepath.Ext(d.path)) {
+	case ".yaml", ".yml":
+		unmarshal = yaml.Unmarshal
+	}
+	var config struct {
+		Peers []string `json:"peers" yaml:"peers"`
+	}
+	if err := unmarshal(data, &config); err != nil {
+		// 兼容直接写成节点数组的格式
+		if err := unmarshal(data, &config.Peers); err != nil {
+			return nil, fmt.Errorf("parse %s failed: %v", d.path, err)
+		}
+	}
+	sort.Strings(config.Peers)
+	return config.Peers, nil
+}
+
+// equalPeers 判断两份排好序的节点列表是否相同
+func equalPeers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 测试各实现是否实现了Discovery接口
+var (
+	_ Discovery = (*StaticDiscovery)(nil)
+	_ Discovery = (*FileDiscovery)(nil)
+	_ Discovery = (*EtcdDiscovery)(nil)
+	_ Registrar = (*EtcdDiscovery)(nil)
+)
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStaticDiscovery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := NewStaticDiscovery("localhost:8001", "localhost:8002").Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"localhost:8001", "localhost:8002"}
+	if peers := <-ch; !reflect.DeepEqual(peers, expect) {
+		t.Fatalf("Actual: %v\tExpect: %v", peers, expect)
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed after ctx done")
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"peers.json": `{"peers": ["localhost:8002", "localhost:8001"]}`,
+		"peers.yaml": "peers:\n  - localhost:8002\n  - localhost:8001\n",
+		"list.json":  `["localhost:8002", "localhost:8001"]`,
+	}
+	expect := []string{"localhost:8001", "localhost:8002"}
+	for name, content := range cases {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		peers, err := NewFileDiscovery(path, 0).load()
+		if err != nil || !reflect.DeepEqual(peers, expect) {
+			t.Fatalf("[%s] Actual: %v %v\tExpect: %v", name, peers, err, expect)
+		}
+	}
+}
+
+func TestFileDiscoveryWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	if err := os.WriteFile(path, []byte(`["localhost:8001"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewFileDiscovery(path, 10*time.Millisecond)
+	warnings := make(chanLogger, 1)
+	d.SetLogger(warnings)
+	ch, err := d.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers := <-ch; !reflect.DeepEqual(peers, []string{"localhost:8001"}) {
+		t.Fatalf("unexpected initial peers %v", peers)
+	}
+
+	if err := os.WriteFile(path, []byte(`["localhost:8001", "localhost:8003"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case peers := <-ch:
+		if !reflect.DeepEqual(peers, []string{"localhost:8001", "localhost:8003"}) {
+			t.Fatalf("unexpected peers %v after file changed", peers)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("file change should be noticed")
+	}
+
+	// 文件格式错误时保留上一次的节点列表 并通过Logger告警
+	if err := os.WriteFile(path, []byte(`{`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-warnings:
+		if msg != "reload discovery file failed" {
+			t.Fatalf("unexpected warning %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reload failure should be logged")
+	}
+}
+
+// chanLogger 将告警日志的msg发送到channel 满时丢弃
+type chanLogger chan string
+
+func (l chanLogger) Info(string, ...any) {}
+
+func (l chanLogger) Warn(msg string, _ ...any) {
+	select {
+	case l <- msg:
+	default:
+	}
+}
@@ -7,19 +7,20 @@ import (
 	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/naming/endpoints"
-	"log"
+	"log/slog"
 	"time"
 )
 
 var (
-	defaultEtcdConfig = clientv3.Config{
+	// DefaultEtcdConfig 是没有指定etcd配置时使用的默认配置
+	DefaultEtcdConfig = clientv3.Config{
 		Endpoints:   []string{"localhost:2379"},
 		DialTimeout: 5 * time.Second,
 	}
 )
 
 // etcdAdd 在租赁模式添加一对kv至etcd
-func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr string) error {
+func etcdAdd(ctx context.Context, c *clientv3.Client, lid clientv3.LeaseID, service string, addr string) error {
 	//该函数用于创建一个 Endpoints Manager（端点管理器）对象。这个管理器用于管理服务的端点（Endpoints）。
 	//c 参数通常包含了一些配置信息，例如服务发现配置等，而 service 参数是服务的名称，表示你要管理的服务。
 	em, err := endpoints.NewManager(c, service)
@@ -28,26 +29,48 @@ func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr stri
 	}
 	//将服务的键值对注册到etcd中
 	//这里的service+"/"+addr是服务的键。endpoints.Endpoint{Addr: addr}一般为localhost:9999是服务的值，通常是服务地址和端口
-	return em.AddEndpoint(c.Ctx(), service+"/"+addr, endpoints.Endpoint{Addr: addr}, clientv3.WithLease(lid))
+	return em.AddEndpoint(ctx, service+"/"+addr, endpoints.Endpoint{Addr: addr}, clientv3.WithLease(lid))
 }
 
 // Register 注册一个服务至etcd
 // 注意 Register将不会return 如果没有error的话
 func Register(service string, addr string, stop chan error) error {
+	return RegisterWithConfig(DefaultEtcdConfig, nil, service, addr, stop)
+}
+
+// RegisterWithConfig 与 Register 相同 但使用指定的etcd配置 日志输出至logger 为nil时使用slog.Default()
+// 申请租约、写入与撤销记录的超时时间与config.DialTimeout相同 etcd不可达时不会一直阻塞
+func RegisterWithConfig(config clientv3.Config, logger Logger, service string, addr string, stop chan error) error {
 	// 创建一个etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
+	cli, err := clientv3.New(config)
 	if err != nil {
 		return fmt.Errorf("create etcd client failed: %v", err)
 	}
 	defer cli.Close()
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return register(cli, config.DialTimeout, logger, service, addr, stop)
+}
+
+// register 使用给定的etcd client注册服务 并维持租约直至收到stop信号
+// 每次访问etcd最多等待timeout 为0时使用 DefaultEtcdConfig 的DialTimeout
+func register(cli *clientv3.Client, timeout time.Duration, logger Logger, service string, addr string, stop chan error) error {
+	if timeout <= 0 {
+		timeout = DefaultEtcdConfig.DialTimeout
+	}
 	// 创建一个租约 配置5秒过期
-	resp, err := cli.Grant(context.Background(), 5)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	resp, err := cli.Grant(ctx, 5)
+	cancel()
 	if err != nil {
 		return fmt.Errorf("create lease failed: %v", err)
 	}
 	leaseId := resp.ID
 	// 注册服务
-	err = etcdAdd(cli, leaseId, service, addr) //注册服务需要用含有Etcd服务器地址的客户端，租约时间，服务名称，服务地址
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	err = etcdAdd(ctx, cli, leaseId, service, addr) //注册服务需要用含有Etcd服务器地址的客户端，租约时间，服务名称，服务地址
+	cancel()
 	if err != nil {
 		return fmt.Errorf("add etcd record failed: %v", err)
 	}
@@ -57,22 +80,24 @@ func Register(service string, addr string, stop chan error) error {
 		return fmt.Errorf("set keepalive failed: %v", err)
 	}
 
-	log.Printf("[%s] register service ok\n", addr)
+	logger.Info("register service ok", "service", service, "addr", addr)
 	for {
 		select {
 		case err := <-stop:
 			if err != nil {
-				log.Println(err)
+				logger.Warn("register stopped with error", "addr", addr, "err", err)
 			}
 			return err
 		case <-cli.Ctx().Done():
-			log.Println("service closed")
+			logger.Info("service closed", "addr", addr)
 			return nil
 		case _, ok := <-ch:
 			// 监听租约
 			if !ok {
-				log.Println("keep alive channel closed")
-				_, err := cli.Revoke(context.Background(), leaseId)
+				logger.Warn("keep alive channel closed", "addr", addr)
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				_, err := cli.Revoke(ctx, leaseId)
 				return err
 			}
 			//log.Printf("Recv reply from service: %s/%s, ttl:%d", service, addr, resp.TTL)
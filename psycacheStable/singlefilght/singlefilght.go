@@ -1,8 +1,9 @@
 package singlefilght
 
 import (
-	"log"
+	"context"
 	"sync"
+	"time"
 )
 
 // singlefilght 为psycache提供缓存击穿的保护
@@ -13,39 +14,76 @@ import (
 // flight载有我们要的缓存数据 称为packet
 
 type packet struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{} // packet装载完成后关闭
+	val  interface{}
+	err  error
 }
 
 type Flight struct {
+	// Timeout 限制每次起飞的fn的运行时长 为0时不限制
+	// fn不继承任何调用者的deadline 第一个调用者的deadline很短也不会让后来的调用者失败
+	Timeout time.Duration
+
 	mu     sync.Mutex
 	flight map[string]*packet
 }
 
 // Fly 负责key航班的飞行 fn是获取packet的方法
-func (f *Flight) Fly(key string, fn func() (interface{}, error)) (interface{}, error) {
+// 每个调用者只会等待到自己的ctx结束为止 某个调用者取消等待并不会取消共享的fn
+// fn收到的ctx保留了第一个调用者的值 但不会随其被取消 deadline由Timeout决定
+func (f *Flight) Fly(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	val, err, _ := f.FlyShared(ctx, key, fn)
+	return val, err
+}
+
+// FlyShared 与 Fly 相同 shared指明本次调用是否搭乘了其他调用者已经起飞的航班
+func (f *Flight) FlyShared(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (val interface{}, err error, shared bool) {
+	if err := ctx.Err(); err != nil { // 调用者已经放弃 无需起飞
+		return nil, err, false
+	}
 	f.mu.Lock()
 	if f.flight == nil {
 		f.flight = make(map[string]*packet)
 	}
 	if p, ok := f.flight[key]; ok {
 		f.mu.Unlock()
-		p.wg.Wait()
-		log.Println("get packet succeed!")
-		return p.val, p.err
+		val, err := p.wait(ctx)
+		return val, err, true
 	}
-	p := new(packet)
-	p.wg.Add(1)
+	p := &packet{done: make(chan struct{})}
 	f.flight[key] = p
 	f.mu.Unlock()
 
-	p.val, p.err = fn()
-	p.wg.Done()
+	go func() {
+		flyCtx, cancel := detach(ctx, f.Timeout)
+		defer cancel()
+		p.val, p.err = fn(flyCtx)
+		close(p.done)
 
-	f.mu.Lock()
-	delete(f.flight, key) // 航班已完成
-	f.mu.Unlock()
+		f.mu.Lock()
+		delete(f.flight, key) // 航班已完成
+		f.mu.Unlock()
+	}()
 
-	return p.val, p.err
+	val, err = p.wait(ctx)
+	return val, err, false
+}
+
+// wait 等待packet装载完成 或者调用者的ctx结束
+func (p *packet) wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-p.done:
+		return p.val, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// detach 剥离ctx的取消信号与deadline 但保留其携带的值 timeout大于0时重新设置超时
+func detach(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	detached := context.WithoutCancel(ctx)
+	if timeout > 0 {
+		return context.WithTimeout(detached, timeout)
+	}
+	return context.WithCancel(detached)
 }
@@ -0,0 +1,125 @@
+package singlefilght
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFly(t *testing.T) {
+	var f Flight
+	v, err := f.Fly(context.Background(), "key", func(context.Context) (interface{}, error) {
+		return "bar", nil
+	})
+	if err != nil || v.(string) != "bar" {
+		t.Fatalf("Fly got %v %v, expect bar <nil>", v, err)
+	}
+}
+
+func TestFlyCancelledWaiter(t *testing.T) {
+	var f Flight
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", ctx.Err()
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := f.Fly(context.Background(), "key", fn)
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// 第二个调用者等待同一航班 并在中途取消
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := f.Fly(ctx, "key", fn); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled waiter should get context.Canceled, but %v got", err)
+	}
+
+	// 取消等待不应取消共享的加载
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("shared load should not be cancelled, but %v got", err)
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("fn should be called once, but %d got", calls)
+	}
+}
+
+func TestFlyDeadline(t *testing.T) {
+	f := Flight{Timeout: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := f.Fly(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) < 30*time.Minute {
+			return nil, errors.New("deadline should come from Timeout instead of the caller")
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 第一个调用者的deadline很短 只有它自己超时 后来的调用者仍能拿到结果
+func TestFlyWaitersDeadlines(t *testing.T) {
+	var f Flight
+	started := make(chan struct{})
+	short, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	shortErr := make(chan error, 1)
+	go func() {
+		_, err := f.Fly(short, "key", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			return "v", ctx.Err()
+		})
+		shortErr <- err
+	}()
+	<-started
+	long, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	v, err := f.Fly(long, "key", func(context.Context) (interface{}, error) {
+		return nil, errors.New("should share the in-flight call")
+	})
+	if err != nil || v != "v" {
+		t.Fatalf("Actual: %v %v\tExpect: v", v, err)
+	}
+	if err := <-shortErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Actual: %v\tExpect: %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestFlyShared(t *testing.T) {
+	var f Flight
+	release := make(chan struct{})
+	fn := func(context.Context) (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+	result := make(chan bool, 1)
+	go func() {
+		_, _, shared := f.FlyShared(context.Background(), "key", fn)
+		result <- shared
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	if v, err, shared := f.FlyShared(context.Background(), "key", fn); err != nil || v.(string) != "bar" || !shared {
+		t.Fatalf("second caller should share the flight, got %v %v %v", v, err, shared)
+	}
+	if <-result {
+		t.Fatal("first caller should not be marked as shared")
+	}
+}